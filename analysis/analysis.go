@@ -0,0 +1,239 @@
+// Package analysis computes the statistics of A/B tests from the exposure and
+// conversion events of their doormen.
+package analysis
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"sync"
+
+	"github.com/didiercrunch/doorman"
+	"github.com/didiercrunch/doorman/internal/stats"
+)
+
+// SampleRatioMismatchAlpha is the significance level bellow which the
+// exposures are considered not to follow the probabilities of the doorman.
+const SampleRatioMismatchAlpha = 0.001
+
+// ErrConversionWithoutExposure rejects the conversions outnumbering the
+// exposures of their case, since only exposed units convert.
+var ErrConversionWithoutExposure = errors.New("more conversions than exposures")
+
+// OrphanConversionsError counts the conversions IngestJSON skipped because
+// they came before their exposure.  It wraps ErrConversionWithoutExposure.
+type OrphanConversionsError struct {
+	Count int
+}
+
+func (e *OrphanConversionsError) Error() string {
+	return strconv.Itoa(e.Count) + " conversions without exposure skipped"
+}
+
+func (e *OrphanConversionsError) Unwrap() error {
+	return ErrConversionWithoutExposure
+}
+
+type EventKind string
+
+const (
+	Exposure   EventKind = "exposure"   // a unit has been shown a case
+	Conversion EventKind = "conversion" // an exposed unit has converted
+)
+
+type Event struct {
+	Kind      EventKind `json:"kind"`
+	DoormanId string    `json:"doorman_id"`
+	Case      uint      `json:"case"`
+}
+
+type experiment struct {
+	probabilities []*big.Rat
+	exposures     []int
+	conversions   []int
+}
+
+// Analyzer counts the events of the added doormen.  It is goroutine safe.
+type Analyzer struct {
+	mu          sync.Mutex
+	experiments map[string]*experiment
+}
+
+func New() *Analyzer {
+	return &Analyzer{experiments: make(map[string]*experiment)}
+}
+
+// Add starts counting the events of w.  The sample ratio is checked against
+// the probabilities of w at the time of the call.
+func (a *Analyzer) Add(w *doorman.Doorman) {
+	probabilities := w.CopyProbabilities()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.experiments[w.Id] = &experiment{
+		probabilities: probabilities,
+		exposures:     make([]int, len(probabilities)),
+		conversions:   make([]int, len(probabilities)),
+	}
+}
+
+// Ingest counts e.  The conversions of a case are rejected once they would
+// outnumber its exposures, so the exposures are ingested first.
+func (a *Analyzer) Ingest(e *Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	exp, ok := a.experiments[e.DoormanId]
+	if !ok {
+		return errors.New("unknown doorman " + e.DoormanId)
+	}
+	if int(e.Case) >= len(exp.exposures) {
+		return errors.New("case out of range")
+	}
+	switch e.Kind {
+	case Exposure:
+		exp.exposures[e.Case]++
+	case Conversion:
+		if exp.conversions[e.Case] >= exp.exposures[e.Case] {
+			return ErrConversionWithoutExposure
+		}
+		exp.conversions[e.Case]++
+	default:
+		return errors.New("unknown event kind " + string(e.Kind))
+	}
+	return nil
+}
+
+// IngestJSON ingests a stream of JSON encoded events.  The logs of events are
+// not strictly ordered, so the conversions coming before their exposure are
+// skipped and counted by the OrphanConversionsError returned at the end.
+func (a *Analyzer) IngestJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	orphans := 0
+	for {
+		e := new(Event)
+		if err := d.Decode(e); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := a.Ingest(e); err == ErrConversionWithoutExposure {
+			orphans++
+		} else if err != nil {
+			return err
+		}
+	}
+	if orphans > 0 {
+		return &OrphanConversionsError{orphans}
+	}
+	return nil
+}
+
+type Variant struct {
+	Case        uint    `json:"case"`
+	Exposures   int     `json:"exposures"`
+	Conversions int     `json:"conversions"`
+	Rate        float64 `json:"rate"`
+	Low         float64 `json:"low"`  // the lower bound of the Wilson confidence interval of the rate
+	High        float64 `json:"high"` // the upper bound of the Wilson confidence interval of the rate
+}
+
+// Comparison is the two-proportion z-test of a variant against the control.
+type Comparison struct {
+	Case       uint    `json:"case"`
+	Control    uint    `json:"control"`
+	Difference float64 `json:"difference"` // the rate of the variant minus the rate of the control
+	Z          float64 `json:"z"`
+	PValue     float64 `json:"p_value"` // two-sided
+}
+
+// SampleRatio is the chi-squared test of the exposures against the
+// probabilities of the doorman.  A mismatch usually means a bug in the
+// bucketing or in the collection of the events, which invalidates the test.
+type SampleRatio struct {
+	ChiSquared       float64 `json:"chi_squared"`
+	DegreesOfFreedom int     `json:"degrees_of_freedom"`
+	PValue           float64 `json:"p_value"`
+	Mismatch         bool    `json:"mismatch"`
+}
+
+type Report struct {
+	DoormanId   string        `json:"doorman_id"`
+	Confidence  float64       `json:"confidence"`
+	Variants    []*Variant    `json:"variants"`
+	Comparisons []*Comparison `json:"comparisons"` // each variant against the control case 0
+	SampleRatio *SampleRatio  `json:"sample_ratio"`
+}
+
+// Report returns the statistics of a doorman with confidence intervals at
+// the confidence level, for example 0.95.
+func (a *Analyzer) Report(doormanId string, confidence float64) (*Report, error) {
+	if confidence <= 0 || confidence >= 1 {
+		return nil, errors.New("confidence must be in (0, 1)")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	exp, ok := a.experiments[doormanId]
+	if !ok {
+		return nil, errors.New("unknown doorman " + doormanId)
+	}
+	z := stats.NormalQuantile(1 - (1-confidence)/2)
+	r := &Report{DoormanId: doormanId, Confidence: confidence}
+	for i := range exp.exposures {
+		v := &Variant{Case: uint(i), Exposures: exp.exposures[i], Conversions: exp.conversions[i]}
+		v.Rate, v.Low, v.High = wilson(v.Conversions, v.Exposures, z)
+		r.Variants = append(r.Variants, v)
+	}
+	for i := 1; i < len(r.Variants); i++ {
+		r.Comparisons = append(r.Comparisons, compare(r.Variants[0], r.Variants[i]))
+	}
+	r.SampleRatio = sampleRatio(exp)
+	return r, nil
+}
+
+// wilson returns the rate of successes and its Wilson score interval.
+func wilson(successes, n int, z float64) (float64, float64, float64) {
+	if n == 0 {
+		return 0, 0, 1
+	}
+	nf := float64(n)
+	p := float64(successes) / nf
+	denominator := 1 + z*z/nf
+	center := (p + z*z/(2*nf)) / denominator
+	margin := z * math.Sqrt(p*(1-p)/nf+z*z/(4*nf*nf)) / denominator
+	return p, math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+func compare(control, variant *Variant) *Comparison {
+	c := &Comparison{Case: variant.Case, Control: control.Case, Difference: variant.Rate - control.Rate, PValue: 1}
+	n := float64(control.Exposures + variant.Exposures)
+	if control.Exposures == 0 || variant.Exposures == 0 {
+		return c
+	}
+	pooled := float64(control.Conversions+variant.Conversions) / n
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(control.Exposures) + 1/float64(variant.Exposures)))
+	if se == 0 {
+		return c
+	}
+	c.Z = c.Difference / se
+	c.PValue = 2 * stats.NormalCDF(-math.Abs(c.Z))
+	return c
+}
+
+func sampleRatio(exp *experiment) *SampleRatio {
+	total := 0
+	for _, e := range exp.exposures {
+		total += e
+	}
+	expected := make([]float64, len(exp.probabilities))
+	for i, p := range exp.probabilities {
+		f, _ := p.Float64()
+		expected[i] = f * float64(total)
+	}
+	s := new(SampleRatio)
+	s.ChiSquared, s.DegreesOfFreedom = stats.ChiSquared(exp.exposures, expected)
+	s.PValue = stats.ChiSquaredPValue(s.ChiSquared, s.DegreesOfFreedom)
+	s.Mismatch = s.PValue < SampleRatioMismatchAlpha
+	return s
+}
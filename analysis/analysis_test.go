@@ -0,0 +1,153 @@
+package analysis
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/didiercrunch/doorman"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func assertAlmostEqual(t *testing.T, expected, received, epsilon float64) {
+	if math.Abs(expected-received) > epsilon {
+		t.Error("received", received, "but expected", expected)
+	}
+}
+
+func newAnalyzer(t *testing.T, probabilities ...*big.Rat) (*Analyzer, string) {
+	id := base64.URLEncoding.EncodeToString([]byte("0123456789abcdef"))
+	w, err := doorman.New(id, probabilities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New()
+	a.Add(w)
+	return a, id
+}
+
+func ingest(t *testing.T, a *Analyzer, id string, c uint, exposures, conversions int) {
+	for i := 0; i < exposures; i++ {
+		if err := a.Ingest(&Event{Exposure, id, c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < conversions; i++ {
+		if err := a.Ingest(&Event{Conversion, id, c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	a, id := newAnalyzer(t, big.NewRat(1, 2), big.NewRat(1, 2))
+	ingest(t, a, id, 0, 1000, 200)
+	ingest(t, a, id, 1, 1000, 250)
+
+	r, err := a.Report(id, 0.95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	control := r.Variants[0]
+	assertAlmostEqual(t, 0.2, control.Rate, 1e-12)
+	assertAlmostEqual(t, 0.1764, control.Low, 1e-4)
+	assertAlmostEqual(t, 0.2259, control.High, 1e-4)
+
+	c := r.Comparisons[0]
+	if c.Case != 1 || c.Control != 0 {
+		t.Error("bad comparison", c)
+	}
+	assertAlmostEqual(t, 0.05, c.Difference, 1e-12)
+	assertAlmostEqual(t, 2.6774, c.Z, 1e-4)
+	assertAlmostEqual(t, 0.00742, c.PValue, 1e-5)
+
+	if r.SampleRatio.Mismatch || r.SampleRatio.ChiSquared != 0 {
+		t.Error("bad sample ratio", r.SampleRatio)
+	}
+}
+
+func TestSampleRatioMismatch(t *testing.T) {
+	a, id := newAnalyzer(t, big.NewRat(1, 2), big.NewRat(1, 2))
+	ingest(t, a, id, 0, 5300, 0)
+	ingest(t, a, id, 1, 4700, 0)
+	r, err := a.Report(id, 0.95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.SampleRatio.Mismatch || r.SampleRatio.DegreesOfFreedom != 1 {
+		t.Error("a 53/47 split of 10000 exposures should mismatch", r.SampleRatio)
+	}
+	if c := r.Comparisons[0]; c.PValue != 1 || c.Z != 0 {
+		t.Error("no conversion should not be significant", c)
+	}
+}
+
+func TestIngestJSON(t *testing.T) {
+	a, id := newAnalyzer(t, big.NewRat(1, 4), big.NewRat(3, 4))
+	events := `{"kind": "exposure", "doorman_id": "` + id + `", "case": 1}
+	{"kind": "conversion", "doorman_id": "` + id + `", "case": 1}
+	{"kind": "exposure", "doorman_id": "` + id + `", "case": 0}`
+	if err := a.IngestJSON(strings.NewReader(events)); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := a.Report(id, 0.9)
+	if r.Variants[0].Exposures != 1 || r.Variants[1].Exposures != 1 || r.Variants[1].Conversions != 1 {
+		t.Error("bad variants", r.Variants[0], r.Variants[1])
+	}
+
+	if err := a.IngestJSON(strings.NewReader(`{"kind": "exposure", "doorman_id": "foo"}`)); err == nil {
+		t.Error("should received an unknown doorman error")
+	}
+	if err := a.Ingest(&Event{Exposure, id, 2}); err == nil {
+		t.Error("should received a case out of range error")
+	}
+	if err := a.Ingest(&Event{"click", id, 0}); err == nil {
+		t.Error("should received an unknown kind error")
+	}
+	if err := a.Ingest(&Event{Conversion, id, 1}); err != ErrConversionWithoutExposure {
+		t.Error("should received a conversion without exposure error", err)
+	}
+	if _, err := a.Report(id, 1); err == nil {
+		t.Error("should received a bad confidence error")
+	}
+}
+
+func TestIngestJSONOrphanConversions(t *testing.T) {
+	a, id := newAnalyzer(t, big.NewRat(1, 2), big.NewRat(1, 2))
+	events := `{"kind": "conversion", "doorman_id": "` + id + `", "case": 0}
+	{"kind": "exposure", "doorman_id": "` + id + `", "case": 0}
+	{"kind": "conversion", "doorman_id": "` + id + `", "case": 1}
+	{"kind": "exposure", "doorman_id": "` + id + `", "case": 1}
+	{"kind": "conversion", "doorman_id": "` + id + `", "case": 1}`
+	err := a.IngestJSON(strings.NewReader(events))
+	if orphans, ok := err.(*OrphanConversionsError); !ok || orphans.Count != 2 || !errors.Is(err, ErrConversionWithoutExposure) {
+		t.Error("should received two orphan conversions", err)
+	}
+	r, _ := a.Report(id, 0.9)
+	if r.Variants[0].Exposures != 1 || r.Variants[1].Exposures != 1 || r.Variants[1].Conversions != 1 {
+		t.Error("the stream should be read to its end", r.Variants[0], r.Variants[1])
+	}
+}
+
+func TestAddWhileUpdated(t *testing.T) {
+	id := base64.URLEncoding.EncodeToString([]byte("0123456789abcdef"))
+	w, err := doorman.New(id, []*big.Rat{big.NewRat(1, 2), big.NewRat(1, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := int64(1); i <= 100; i++ {
+			w.Update(&shared.DoormanUpdater{Id: id, Timestamp: i, Probabilities: []*big.Rat{big.NewRat(1, 4), big.NewRat(3, 4)}})
+		}
+	}()
+	a := New()
+	for i := 0; i < 100; i++ {
+		a.Add(w)
+	}
+	<-done
+}
@@ -0,0 +1,96 @@
+package assignmentstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type store interface {
+	Get(doormanId, key string) (uint, bool, error)
+	Set(doormanId, key string, c uint) error
+	Reset(doormanId string) error
+}
+
+func assertAssignment(t *testing.T, s store, doormanId, key string, expt uint, exptOk bool) {
+	if c, ok, err := s.Get(doormanId, key); err != nil {
+		t.Error(err)
+	} else if ok != exptOk || c != expt {
+		t.Error("bad assignment for", doormanId, key, c, ok)
+	}
+}
+
+func testStore(t *testing.T, s store) {
+	assertAssignment(t, s, "foo", "alice", 0, false)
+	if err := s.Set("foo", "alice", 2); err != nil {
+		t.Error(err)
+	}
+	if err := s.Set("bar", "alice", 1); err != nil {
+		t.Error(err)
+	}
+	assertAssignment(t, s, "foo", "alice", 2, true)
+	assertAssignment(t, s, "bar", "alice", 1, true)
+
+	if err := s.Set("foo", "alice", 3); err != nil {
+		t.Error(err)
+	}
+	assertAssignment(t, s, "foo", "alice", 3, true)
+
+	// the empty key is a key like any other
+	if err := s.Set("foo", "", 1); err != nil {
+		t.Error(err)
+	}
+	assertAssignment(t, s, "foo", "", 1, true)
+
+	if err := s.Reset("foo"); err != nil {
+		t.Error(err)
+	}
+	assertAssignment(t, s, "foo", "alice", 0, false)
+	assertAssignment(t, s, "foo", "", 0, false)
+	assertAssignment(t, s, "bar", "alice", 1, true)
+
+	if err := s.Reset("unknown"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	testStore(t, NewLRU(10))
+}
+
+func TestLRUEviction(t *testing.T) {
+	s := NewLRU(2)
+	s.Set("foo", "a", 0)
+	s.Set("foo", "b", 1)
+	s.Get("foo", "a")
+	s.Set("foo", "c", 2)
+	if s.Len() != 2 {
+		t.Error("bad length", s.Len())
+	}
+	assertAssignment(t, s, "foo", "a", 0, true)
+	assertAssignment(t, s, "foo", "b", 0, false)
+	assertAssignment(t, s, "foo", "c", 2, true)
+}
+
+func TestBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assignmentstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "assignments.db")
+
+	s, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	s.Close()
+
+	if s, err = OpenBolt(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	assertAssignment(t, s, "bar", "alice", 1, true)
+}
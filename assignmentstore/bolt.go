@@ -0,0 +1,79 @@
+package assignmentstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt is an on-disk assignment store backed by a bolt database.  Each doorman
+// has its own bucket, keyed by the raw keys, so resetting a doorman is a single
+// bucket deletion.  Bolt rejecting the empty key, its case plus one is the
+// sequence of the bucket.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens, or creates, the bolt database at path.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &Bolt{db}, nil
+}
+
+func (s *Bolt) Get(doormanId, key string) (uint, bool, error) {
+	var c uint64
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(doormanId))
+		if b == nil {
+			return nil
+		}
+		if key == "" {
+			c, ok = b.Sequence()-1, b.Sequence() > 0
+			return nil
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		var n int
+		if c, n = binary.Uvarint(v); n <= 0 {
+			return errors.New("corrupted assignment")
+		}
+		ok = true
+		return nil
+	})
+	return uint(c), ok, err
+}
+
+func (s *Bolt) Set(doormanId, key string, c uint) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(doormanId))
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			return b.SetSequence(uint64(c) + 1)
+		}
+		v := make([]byte, binary.MaxVarintLen64)
+		return b.Put([]byte(key), v[:binary.PutUvarint(v, uint64(c))])
+	})
+}
+
+func (s *Bolt) Reset(doormanId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(doormanId)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
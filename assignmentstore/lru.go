@@ -0,0 +1,76 @@
+package assignmentstore
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruKey struct {
+	doormanId string
+	key       string
+}
+
+type lruEntry struct {
+	lruKey
+	c uint
+}
+
+// LRU is an in-memory assignment store that forgets the least recently used
+// assignments once it holds Size of them.  It is goroutine safe.
+type LRU struct {
+	Size  int
+	mu    sync.Mutex
+	ll    *list.List
+	items map[lruKey]*list.Element
+}
+
+func NewLRU(size int) *LRU {
+	return &LRU{Size: size, ll: list.New(), items: make(map[lruKey]*list.Element)}
+}
+
+func (s *LRU) Get(doormanId, key string) (uint, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[lruKey{doormanId, key}]; ok {
+		s.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).c, true, nil
+	}
+	return 0, false, nil
+}
+
+func (s *LRU) Set(doormanId, key string, c uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := lruKey{doormanId, key}
+	if e, ok := s.items[k]; ok {
+		e.Value.(*lruEntry).c = c
+		s.ll.MoveToFront(e)
+		return nil
+	}
+	s.items[k] = s.ll.PushFront(&lruEntry{k, c})
+	for s.Size > 0 && s.ll.Len() > s.Size {
+		e := s.ll.Back()
+		s.ll.Remove(e)
+		delete(s.items, e.Value.(*lruEntry).lruKey)
+	}
+	return nil
+}
+
+func (s *LRU) Reset(doormanId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, e := range s.items {
+		if k.doormanId == doormanId {
+			s.ll.Remove(e)
+			delete(s.items, k)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of assignments in the store.
+func (s *LRU) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
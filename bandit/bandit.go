@@ -0,0 +1,178 @@
+// Package bandit adapts the probabilities of a doorman toward its best
+// performing case, a multi-armed bandit.
+//
+// An Allocator is a Subscriber: once subscribed by a doorman, it periodically
+// recomputes the probabilities from the rewards it received and hands them to
+// the Update method of the doorman, like any transport.  Between two
+// recomputations GetCaseFromData is as stable as with fixed probabilities; a
+// recomputation moves the keys near the boundaries of the cases, which an
+// assignment store prevents if needed.
+package bandit
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// Allocator counts the rewards of the cases of a doorman and turns them into
+// probabilities with its strategy.  It is goroutine safe.
+type Allocator struct {
+	DoormanId string
+	Strategy  Strategy
+	Interval  time.Duration // the time between two recomputations, one minute when zero
+	Precision int64         // the denominator of the probabilities, 10000 when zero
+	Logger    shared.Logger // optional, receives the rejected updates
+	mu        sync.Mutex
+	trials    []float64
+	rewards   []float64
+	timestamp int64
+	stop      chan struct{}
+}
+
+func New(doormanId string, cases int, strategy Strategy) *Allocator {
+	return &Allocator{
+		DoormanId: doormanId,
+		Strategy:  strategy,
+		trials:    make([]float64, cases),
+		rewards:   make([]float64, cases),
+	}
+}
+
+func (a *Allocator) logger() shared.Logger {
+	if a.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return a.Logger
+}
+
+// Reward records a trial of case c that earned reward, for example 1 for a
+// conversion and 0 otherwise.
+func (a *Allocator) Reward(c uint, reward float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if int(c) >= len(a.trials) {
+		return errors.New("case out of range")
+	}
+	a.trials[c]++
+	a.rewards[c] += reward
+	return nil
+}
+
+// nextTimestamp returns the current time in nanoseconds, or the last timestamp
+// plus one if the clock went backward.
+func (a *Allocator) nextTimestamp() int64 {
+	ts := time.Now().UnixNano()
+	if ts <= a.timestamp {
+		ts = a.timestamp + 1
+	}
+	a.timestamp = ts
+	return ts
+}
+
+// Recompute returns an update of the doorman with the probabilities of the
+// strategy and a strictly increasing timestamp.
+func (a *Allocator) Recompute() *shared.DoormanUpdater {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	weights := a.Strategy.Weights(append([]float64(nil), a.trials...), append([]float64(nil), a.rewards...))
+	precision := a.Precision
+	if precision <= 0 {
+		precision = 10000
+	}
+	return &shared.DoormanUpdater{
+		Id:            a.DoormanId,
+		Timestamp:     a.nextTimestamp(),
+		Probabilities: ToProbabilities(weights, precision),
+	}
+}
+
+// ToProbabilities rounds weights to rationals of denominator precision that
+// sum exactly to one, with the largest remainder method.
+func ToProbabilities(weights []float64, precision int64) []*big.Rat {
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	numerators := make([]int64, len(weights))
+	remainders := make([]float64, len(weights))
+	left := precision
+	for i, w := range weights {
+		exact := float64(precision) / float64(len(weights))
+		if sum > 0 {
+			exact = w / sum * float64(precision)
+		}
+		numerators[i] = int64(exact)
+		remainders[i] = exact - float64(numerators[i])
+		left -= numerators[i]
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for i := 0; left > 0 && len(order) > 0; i++ {
+		numerators[order[i%len(order)]]++
+		left--
+	}
+	ret := make([]*big.Rat, len(weights))
+	for i, n := range numerators {
+		ret[i] = big.NewRat(n, precision)
+	}
+	return ret
+}
+
+// Subscribe recomputes the probabilities of the doorman every Interval and
+// hands them to update until Stop is called.  Subscribing again stops the
+// previous subscription.
+func (a *Allocator) Subscribe(doormanId string, update shared.UpdateHandlerFunc) error {
+	if doormanId != a.DoormanId {
+		return errors.New("bad doorman id")
+	}
+	interval := a.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	a.mu.Lock()
+	if a.stop != nil {
+		close(a.stop)
+	}
+	a.stop = make(chan struct{})
+	stop := a.stop
+	a.mu.Unlock()
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				wu := a.Recompute()
+				if err := update(wu); err != nil {
+					a.logger().Error("cannot update doorman", "doorman_id", a.DoormanId, "transport", a.Transport(), "timestamp", wu.Timestamp, "error", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (a *Allocator) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+}
+
+func (a *Allocator) Transport() string {
+	return "bandit"
+}
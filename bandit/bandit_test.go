@@ -0,0 +1,141 @@
+package bandit
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func sum(probabilities []*big.Rat) *big.Rat {
+	ret := new(big.Rat)
+	for _, p := range probabilities {
+		ret.Add(ret, p)
+	}
+	return ret
+}
+
+func TestToProbabilities(t *testing.T) {
+	for _, weights := range [][]float64{{1, 1, 1}, {0.2, 0.3, 0.5}, {0, 0}, {0.12345, 0.6789, 0.1}} {
+		p := ToProbabilities(weights, 10000)
+		if !doorman.IsEqual(sum(p), doorman.ONE) {
+			t.Error("probabilities should sum to one", weights, p)
+		}
+	}
+	p := ToProbabilities([]float64{1, 1, 1}, 100)
+	if p[0].Cmp(big.NewRat(34, 100)) != 0 || p[1].Cmp(big.NewRat(33, 100)) != 0 {
+		t.Error("bad rounding", p)
+	}
+}
+
+func TestEpsilonGreedy(t *testing.T) {
+	s := &EpsilonGreedy{Epsilon: 0.3}
+	w := s.Weights([]float64{10, 10, 10}, []float64{1, 5, 2})
+	if math.Abs(w[0]-0.1) > 1e-12 || math.Abs(w[1]-0.8) > 1e-12 || math.Abs(w[2]-0.1) > 1e-12 {
+		t.Error("bad weights", w)
+	}
+	w = s.Weights([]float64{10, 10}, []float64{5, 5})
+	if w[0] != 0.5 || w[1] != 0.5 {
+		t.Error("ties should be split", w)
+	}
+}
+
+func TestThompsonSampling(t *testing.T) {
+	s := NewThompsonSampling(1)
+	w := s.Weights([]float64{1000, 1000}, []float64{100, 200})
+	if w[1] < 0.99 {
+		t.Error("the best case should have almost all the weight", w)
+	}
+	w = s.Weights([]float64{0, 0}, []float64{0, 0})
+	if w[0] < 0.4 || w[0] > 0.6 {
+		t.Error("without data the weights should be even", w)
+	}
+	if w := new(ThompsonSampling).Weights([]float64{1000, 1000}, []float64{100, 200}); w[1] < 0.99 {
+		t.Error("a zero value should sample too", w)
+	}
+}
+
+func TestRecompute(t *testing.T) {
+	a := New("foo", 2, &EpsilonGreedy{Epsilon: 0.2})
+	if err := a.Reward(2, 1); err == nil {
+		t.Error("should received a case out of range error")
+	}
+	a.Reward(1, 1)
+	a.Reward(0, 0)
+	u1, u2 := a.Recompute(), a.Recompute()
+	if u1.Id != "foo" || u2.Timestamp <= u1.Timestamp {
+		t.Error("timestamps should increase", u1.Timestamp, u2.Timestamp)
+	}
+	if u1.Probabilities[1].Cmp(big.NewRat(9, 10)) != 0 {
+		t.Error("bad probabilities", u1.Probabilities)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	id := base64.URLEncoding.EncodeToString([]byte("0123456789abcdef"))
+	w, err := doorman.New(id, []*big.Rat{big.NewRat(1, 2), big.NewRat(1, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(id, 2, &EpsilonGreedy{})
+	a.Interval = time.Millisecond
+	a.Reward(0, 1)
+	if err := New("bar", 2, &EpsilonGreedy{}).Subscribe(id, w.Update); err == nil {
+		t.Error("should received a bad doorman id error")
+	}
+	if err := w.Subscribe(a); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	for i := 0; i < 1000 && w.Status().LastSeen.IsZero(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if c := w.GetCaseFromString("anything"); c != 0 {
+		t.Error("every key should go to the best case, received", c)
+	}
+	if s := w.Status(); s.Transport != "bandit" {
+		t.Error("bad transport", s.Transport)
+	}
+}
+
+type recordingLogger struct {
+	shared.NopLogger
+	errors chan string
+}
+
+func (l *recordingLogger) Error(msg string, args ...interface{}) {
+	l.errors <- msg
+}
+
+func TestResubscribe(t *testing.T) {
+	a := New("foo", 2, &EpsilonGreedy{})
+	a.Interval = time.Millisecond
+	l := &recordingLogger{errors: make(chan string, 1000)}
+	a.Logger = l
+	var first int32
+	a.Subscribe("foo", func(wu *shared.DoormanUpdater) error {
+		atomic.AddInt32(&first, 1)
+		return nil
+	})
+	a.Subscribe("foo", func(wu *shared.DoormanUpdater) error {
+		return errors.New("rejected")
+	})
+	defer a.Stop()
+	select {
+	case <-l.errors:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rejected updates should be logged")
+	}
+	time.Sleep(10 * time.Millisecond) // the update in flight, if any
+	n := atomic.LoadInt32(&first)
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&first) != n {
+		t.Error("the first subscription should be stopped")
+	}
+}
@@ -0,0 +1,110 @@
+package bandit
+
+import (
+	"math"
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+// Strategy turns the number of trials and the sum of the rewards of each case
+// into the weights of the cases.  The weights are non negative and sum to one.
+type Strategy interface {
+	Weights(trials, rewards []float64) []float64
+}
+
+// EpsilonGreedy gives the case with the best mean reward a weight of
+// 1 - Epsilon and splits Epsilon evenly between every case.
+type EpsilonGreedy struct {
+	Epsilon float64
+}
+
+func (s *EpsilonGreedy) Weights(trials, rewards []float64) []float64 {
+	weights := make([]float64, len(trials))
+	best := []int{}
+	bestMean := math.Inf(-1)
+	for i := range trials {
+		weights[i] = s.Epsilon / float64(len(trials))
+		mean := 0.0
+		if trials[i] > 0 {
+			mean = rewards[i] / trials[i]
+		}
+		if mean > bestMean {
+			best, bestMean = []int{i}, mean
+		} else if mean == bestMean {
+			best = append(best, i)
+		}
+	}
+	for _, i := range best {
+		weights[i] += (1 - s.Epsilon) / float64(len(best))
+	}
+	return weights
+}
+
+// ThompsonSampling gives each case a weight equal to the probability that it
+// is the best case, estimated by sampling the Beta posterior of its rewards.
+// Rewards must be in [0, 1].
+type ThompsonSampling struct {
+	Draws int // the number of Monte Carlo draws, 1000 when zero
+	mu    sync.Mutex
+	r     *mathrand.Rand // seeded with the time on first use when nil
+}
+
+func NewThompsonSampling(seed int64) *ThompsonSampling {
+	return &ThompsonSampling{r: mathrand.New(mathrand.NewSource(seed))}
+}
+
+func (s *ThompsonSampling) Weights(trials, rewards []float64) []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.r == nil {
+		s.r = mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
+	}
+	draws := s.Draws
+	if draws <= 0 {
+		draws = 1000
+	}
+	weights := make([]float64, len(trials))
+	for n := 0; n < draws; n++ {
+		best, bestSample := 0, math.Inf(-1)
+		for i := range trials {
+			alpha := 1 + rewards[i]
+			beta := 1 + trials[i] - rewards[i]
+			if sample := s.beta(alpha, beta); sample > bestSample {
+				best, bestSample = i, sample
+			}
+		}
+		weights[best]++
+	}
+	for i := range weights {
+		weights[i] /= float64(draws)
+	}
+	return weights
+}
+
+func (s *ThompsonSampling) beta(alpha, beta float64) float64 {
+	x := s.gamma(alpha)
+	return x / (x + s.gamma(beta))
+}
+
+// gamma samples a Gamma(shape, 1) variable with the method of Marsaglia and
+// Tsang.
+func (s *ThompsonSampling) gamma(shape float64) float64 {
+	if shape < 1 {
+		return s.gamma(shape+1) * math.Pow(s.r.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := s.r.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := s.r.Float64()
+		if math.Log(u) < x*x/2+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
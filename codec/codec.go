@@ -0,0 +1,76 @@
+// Package codec encodes the doorman updaters on the wire.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+type Codec interface {
+	Name() string        // the name of the codec in the server specification
+	ContentType() string // the media type of the encoded updaters
+	Marshal(wu *shared.DoormanUpdater) ([]byte, error)
+	Unmarshal(data []byte, wu *shared.DoormanUpdater) error
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Protobuf Codec = protobufCodec{}
+	MsgPack  Codec = msgpackCodec{}
+)
+
+var codecs = []Codec{JSON, Protobuf, MsgPack}
+
+// ByName returns the codec named name, JSON when name is empty.
+func ByName(name string) (Codec, error) {
+	if name == "" {
+		return JSON, nil
+	}
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, ErrUnknownCodec
+}
+
+// ByContentType returns the codec of the media type of contentType, JSON when
+// contentType is empty.
+func ByContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range codecs {
+		if c.ContentType() == mediaType {
+			return c, nil
+		}
+	}
+	return nil, ErrUnknownCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(wu *shared.DoormanUpdater) ([]byte, error) {
+	return json.Marshal(wu)
+}
+
+func (jsonCodec) Unmarshal(data []byte, wu *shared.DoormanUpdater) error {
+	return json.Unmarshal(data, wu)
+}
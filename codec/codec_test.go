@@ -0,0 +1,83 @@
+package codec
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestRoundTrip(t *testing.T) {
+	updaters := []*shared.DoormanUpdater{
+		{Id: "foo", Timestamp: 10, Probabilities: []*big.Rat{big.NewRat(1, 4), big.NewRat(3, 4)}},
+		{
+			Id:            "bar",
+			Timestamp:     -3,
+			Probabilities: []*big.Rat{big.NewRat(1, 3), big.NewRat(2, 3)},
+			Killed:        true,
+			ForcedCase:    1,
+			Reset:         true,
+			Algorithm:     2,
+			Sequence:      1 << 40,
+			Prerequisites: []*shared.Prerequisite{{DoormanId: "foo", Cases: []uint{1, 300}}, {DoormanId: "baz"}},
+		},
+	}
+	for _, c := range codecs {
+		for _, wu := range updaters {
+			data, err := c.Marshal(wu)
+			if err != nil {
+				t.Fatal(c.Name(), err)
+			}
+			received := new(shared.DoormanUpdater)
+			if err := c.Unmarshal(data, received); err != nil {
+				t.Fatal(c.Name(), err)
+			}
+			if !reflect.DeepEqual(wu, received) {
+				t.Errorf("%s: received %+v but expected %+v", c.Name(), received, wu)
+			}
+		}
+	}
+}
+
+func TestProtobufUnmarshal(t *testing.T) {
+	// id "a", timestamp 5, probability "1/1" and an unknown field 15
+	data := []byte{0x0a, 1, 'a', 0x10, 5, 0x1a, 3, '1', '/', '1', 0x78, 7}
+	wu := new(shared.DoormanUpdater)
+	if err := Protobuf.Unmarshal(data, wu); err != nil {
+		t.Fatal(err)
+	}
+	if wu.Id != "a" || wu.Timestamp != 5 || len(wu.Probabilities) != 1 || wu.Probabilities[0].Cmp(big.NewRat(1, 1)) != 0 {
+		t.Error("bad updater", wu)
+	}
+	if err := Protobuf.Unmarshal(data[:len(data)-1], wu); err == nil {
+		t.Error("expected an error for a truncated message")
+	}
+}
+
+func TestByName(t *testing.T) {
+	for name, expected := range map[string]Codec{"": JSON, "json": JSON, "protobuf": Protobuf, "msgpack": MsgPack} {
+		if c, err := ByName(name); err != nil || c != expected {
+			t.Error("bad codec for", name, c, err)
+		}
+	}
+	if _, err := ByName("xml"); err != ErrUnknownCodec {
+		t.Error("expected an unknown codec", err)
+	}
+}
+
+func TestByContentType(t *testing.T) {
+	for contentType, expected := range map[string]Codec{
+		"":                                JSON,
+		"application/json; charset=utf-8": JSON,
+		"application/x-protobuf":          Protobuf,
+		"application/msgpack":             MsgPack,
+	} {
+		if c, err := ByContentType(contentType); err != nil || c != expected {
+			t.Error("bad codec for", contentType, c, err)
+		}
+	}
+	if _, err := ByContentType("text/xml"); err != ErrUnknownCodec {
+		t.Error("expected an unknown codec", err)
+	}
+}
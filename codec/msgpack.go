@@ -0,0 +1,84 @@
+package codec
+
+import (
+	"math/big"
+
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type msgpackCodec struct{}
+
+// msgpackUpdater is the MessagePack encoding of a DoormanUpdater, with the
+// keys of the JSON encoding and the probabilities written as strings.
+type msgpackUpdater struct {
+	Id            string                 `msgpack:"id"`
+	Timestamp     int64                  `msgpack:"timestamp"`
+	Probabilities []string               `msgpack:"probabilities"`
+	Killed        bool                   `msgpack:"killed,omitempty"`
+	ForcedCase    uint                   `msgpack:"forced_case,omitempty"`
+	Reset         bool                   `msgpack:"reset,omitempty"`
+	Algorithm     int                    `msgpack:"algorithm,omitempty"`
+	Sequence      uint64                 `msgpack:"sequence,omitempty"`
+	Prerequisites []*msgpackPrerequisite `msgpack:"prerequisites,omitempty"`
+}
+
+type msgpackPrerequisite struct {
+	DoormanId string `msgpack:"doorman_id"`
+	Cases     []uint `msgpack:"cases"`
+}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Marshal(wu *shared.DoormanUpdater) ([]byte, error) {
+	m := &msgpackUpdater{
+		Id:            wu.Id,
+		Timestamp:     wu.Timestamp,
+		Probabilities: make([]string, len(wu.Probabilities)),
+		Killed:        wu.Killed,
+		ForcedCase:    wu.ForcedCase,
+		Reset:         wu.Reset,
+		Algorithm:     wu.Algorithm,
+		Sequence:      wu.Sequence,
+	}
+	for i, p := range wu.Probabilities {
+		m.Probabilities[i] = p.String()
+	}
+	for _, p := range wu.Prerequisites {
+		m.Prerequisites = append(m.Prerequisites, &msgpackPrerequisite{p.DoormanId, p.Cases})
+	}
+	return msgpack.Marshal(m)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, wu *shared.DoormanUpdater) error {
+	m := new(msgpackUpdater)
+	if err := msgpack.Unmarshal(data, m); err != nil {
+		return err
+	}
+	*wu = shared.DoormanUpdater{
+		Id:         m.Id,
+		Timestamp:  m.Timestamp,
+		Killed:     m.Killed,
+		ForcedCase: m.ForcedCase,
+		Reset:      m.Reset,
+		Algorithm:  m.Algorithm,
+		Sequence:   m.Sequence,
+	}
+	for _, p := range m.Prerequisites {
+		wu.Prerequisites = append(wu.Prerequisites, &shared.Prerequisite{DoormanId: p.DoormanId, Cases: p.Cases})
+	}
+	for _, s := range m.Probabilities {
+		p, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return errBadProbability
+		}
+		wu.Probabilities = append(wu.Probabilities, p)
+	}
+	return nil
+}
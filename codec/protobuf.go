@@ -0,0 +1,190 @@
+package codec
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/didiercrunch/doorman/shared"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufCodec follows doorman.proto.  It is written with protowire to spare
+// the generated code.
+type protobufCodec struct{}
+
+var errBadProbability = errors.New("bad probability")
+
+func (protobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(wu *shared.DoormanUpdater) ([]byte, error) {
+	var b []byte
+	if wu.Id != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, wu.Id)
+	}
+	if wu.Timestamp != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(wu.Timestamp))
+	}
+	for _, p := range wu.Probabilities {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, p.String())
+	}
+	if wu.Killed {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if wu.ForcedCase != 0 {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(wu.ForcedCase))
+	}
+	if wu.Reset {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if wu.Algorithm != 0 {
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(wu.Algorithm)))
+	}
+	if wu.Sequence != 0 {
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, wu.Sequence)
+	}
+	for _, p := range wu.Prerequisites {
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalPrerequisite(p))
+	}
+	return b, nil
+}
+
+func marshalPrerequisite(p *shared.Prerequisite) []byte {
+	var b []byte
+	if p.DoormanId != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, p.DoormanId)
+	}
+	if len(p.Cases) > 0 {
+		var cases []byte
+		for _, c := range p.Cases {
+			cases = protowire.AppendVarint(cases, uint64(c))
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, cases)
+	}
+	return b
+}
+
+func (protobufCodec) Unmarshal(data []byte, wu *shared.DoormanUpdater) error {
+	*wu = shared.DoormanUpdater{}
+	return unmarshalFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			wu.Id = v
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			wu.Timestamp = int64(v)
+			return n, nil
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return n, nil
+			}
+			p, ok := new(big.Rat).SetString(v)
+			if !ok {
+				return 0, errBadProbability
+			}
+			wu.Probabilities = append(wu.Probabilities, p)
+			return n, nil
+		case num == 4 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			wu.Killed = v != 0
+			return n, nil
+		case num == 5 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			wu.ForcedCase = uint(v)
+			return n, nil
+		case num == 6 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			wu.Reset = v != 0
+			return n, nil
+		case num == 7 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			wu.Algorithm = int(int32(v))
+			return n, nil
+		case num == 8 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			wu.Sequence = v
+			return n, nil
+		case num == 9 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			p, err := unmarshalPrerequisite(v)
+			if err != nil {
+				return 0, err
+			}
+			wu.Prerequisites = append(wu.Prerequisites, p)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func unmarshalPrerequisite(data []byte) (*shared.Prerequisite, error) {
+	p := new(shared.Prerequisite)
+	err := unmarshalFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			p.DoormanId = v
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			p.Cases = append(p.Cases, uint(v))
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			packed, n := protowire.ConsumeBytes(b)
+			for len(packed) > 0 {
+				v, m := protowire.ConsumeVarint(packed)
+				if m < 0 {
+					return m, nil
+				}
+				p.Cases = append(p.Cases, uint(v))
+				packed = packed[m:]
+			}
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return p, err
+}
+
+// unmarshalFields calls field on the value of every field of the message
+// data.  field returns the length of the value, negative when malformed.
+func unmarshalFields(data []byte, field func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n, err := field(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package doorman
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	mathrand "math/rand"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// The conformance corpus in testdata/conformance is generated from this
+// implementation with `go test -run TestConformance -update` and is meant to
+// be run by the clients written in other languages.  A new version of the
+// corpus is written in a new file; existing files are never regenerated once
+// published, so any change of algorithm makes TestConformance fail.
+//
+// Version 2 adds the bucketing algorithm of each doorman, version 1 only
+// covers AlgorithmV1.  It runs the doormen of version 1, its base, again with
+// AlgorithmV2 and only lists the cases and positions whose expected case
+// differs; the others expect the case of the base.
+
+const conformanceVersion = 2
+
+type conformanceCase struct {
+	Key      *keyField `json:"key"`
+	Hash     string    `json:"hash"`               // hexadecimal
+	Position string    `json:"position,omitempty"` // the rational generated from the hash, unchecked when taken from the base
+	Case     uint      `json:"case"`
+}
+
+type conformancePosition struct {
+	Position string `json:"position"`
+	Case     uint   `json:"case"`
+}
+
+type conformanceDoorman struct {
+	Id            string                 `json:"id"`
+	Algorithm     int                    `json:"algorithm,omitempty"`
+	Probabilities []string               `json:"probabilities"`
+	Cases         []*conformanceCase     `json:"cases"`
+	Positions     []*conformancePosition `json:"positions"`
+}
+
+type conformanceCorpus struct {
+	Version int                   `json:"version"`
+	Base    int                   `json:"base,omitempty"` // the version whose doormen are run again with the algorithm of these ones
+	Doormen []*conformanceDoorman `json:"doormen"`
+}
+
+func readConformanceCorpus(file string) (*conformanceCorpus, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	corpus := new(conformanceCorpus)
+	return corpus, json.Unmarshal(b, corpus)
+}
+
+func conformanceFile(version int) string {
+	return filepath.Join("testdata", "conformance", fmt.Sprintf("v%d.json", version))
+}
+
+var conformanceDoormen = []struct {
+	id            string
+	probabilities []string
+}{
+	{"AAAAAAAAAAAAAAAAAAAAAA==", []string{"1/2", "1/2"}},
+	{"MTIzNDU2Nzg5MDEyMzQ1Ng==", []string{"1/4", "1/2", "1/4"}},
+	{"XapIHlp_JIxFReURP8Ouyg==", []string{"1/3", "1/3", "1/3"}},
+	{"_____________________w==", []string{"10/100", "40/100", "40/100", "5/100", "5/100"}},
+	{"UEA3JnhoLLhbsw2BhX0Kcg==", []string{"1/1000", "0", "999/1000"}},
+	{"3q2-796tvu_erb7v3q2-7w==", []string{"1"}},
+}
+
+var conformanceRunes = []rune("abcdefghijklmnopqrstuvwxyz0123456789-_ éàçՀայաստանსაქართველოəı日本語🚪")
+
+func randomConformanceKey(r *mathrand.Rand) *keyField {
+	switch r.Intn(5) {
+	case 0:
+		return &keyField{Type: "int", Value: strconv.FormatInt(r.Int63()-r.Int63(), 10)}
+	case 1:
+		return &keyField{Type: "uint64", Value: strconv.FormatUint(uint64(r.Int63())<<1|uint64(r.Intn(2)), 10)}
+	case 2:
+		u := make([]byte, 16)
+		r.Read(u)
+		h := hex.EncodeToString(u)
+		return &keyField{Type: "uuid", Value: h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]}
+	case 3:
+		return &keyField{Type: "key", Fields: []*keyField{
+			{Type: "int", Value: strconv.Itoa(r.Intn(1000000))},
+			randomConformanceString(r),
+		}}
+	}
+	return randomConformanceString(r)
+}
+
+func randomConformanceString(r *mathrand.Rand) *keyField {
+	s := make([]rune, r.Intn(32))
+	for i := range s {
+		s[i] = conformanceRunes[r.Intn(len(conformanceRunes))]
+	}
+	return &keyField{Type: "string", Value: string(s)}
+}
+
+var conformancePositions = []string{"0", "1/1000", "1/4", "1/3", "1/2", "2/3", "3/4", "999/1000", "1"}
+
+// generateConformanceCorpus generates the corpus of version, 1 or 2.
+func generateConformanceCorpus(version int) (*conformanceCorpus, error) {
+	r := mathrand.New(mathrand.NewSource(42))
+	base := &conformanceCorpus{Version: 1}
+	for _, d := range conformanceDoormen {
+		cd, err := generateConformanceDoorman(r, d.id, d.probabilities, 0)
+		if err != nil {
+			return nil, err
+		}
+		base.Doormen = append(base.Doormen, cd)
+	}
+	if version == 1 {
+		return base, nil
+	}
+	ret := &conformanceCorpus{Version: version, Base: base.Version}
+	for _, bd := range base.Doormen {
+		cd, err := diffConformanceDoorman(bd, AlgorithmV2)
+		if err != nil {
+			return nil, err
+		}
+		ret.Doormen = append(ret.Doormen, cd)
+	}
+	return ret, nil
+}
+
+// diffConformanceDoorman returns the cases and positions of bd whose case
+// differs with algorithm.
+func diffConformanceDoorman(bd *conformanceDoorman, algorithm int) (*conformanceDoorman, error) {
+	w, err := New(bd.Id, getProbs(bd.Probabilities...))
+	if err != nil {
+		return nil, err
+	}
+	w.Algorithm = algorithm
+	cd := &conformanceDoorman{Id: bd.Id, Algorithm: algorithm, Probabilities: bd.Probabilities, Cases: []*conformanceCase{}, Positions: []*conformancePosition{}}
+	for _, c := range bd.Cases {
+		b, err := c.Key.encode()
+		if err != nil {
+			return nil, err
+		}
+		h := w.Hash(b)
+		if cs := w.GetCaseFromData(b); cs != c.Case {
+			cd.Cases = append(cd.Cases, &conformanceCase{Key: c.Key, Hash: c.Hash, Position: w.Position(h).RatString(), Case: cs})
+		}
+	}
+	for _, p := range bd.Positions {
+		if cs := w.GetCase(getProbs(p.Position)[0]); cs != p.Case {
+			cd.Positions = append(cd.Positions, &conformancePosition{p.Position, cs})
+		}
+	}
+	return cd, nil
+}
+
+// resolveConformanceCorpus completes the doormen of a corpus with the cases
+// and positions of the same doormen in its base.
+func resolveConformanceCorpus(corpus *conformanceCorpus) (*conformanceCorpus, error) {
+	if corpus.Base == 0 {
+		return corpus, nil
+	}
+	base, err := readConformanceCorpus(conformanceFile(corpus.Base))
+	if err != nil {
+		return nil, err
+	}
+	if base, err = resolveConformanceCorpus(base); err != nil {
+		return nil, err
+	}
+	baseDoormen := make(map[string]*conformanceDoorman)
+	for _, bd := range base.Doormen {
+		baseDoormen[bd.Id] = bd
+	}
+	ret := &conformanceCorpus{Version: corpus.Version}
+	for _, d := range corpus.Doormen {
+		bd, ok := baseDoormen[d.Id]
+		if !ok {
+			return nil, fmt.Errorf("doorman %s not in the base corpus", d.Id)
+		}
+		resolved := &conformanceDoorman{Id: d.Id, Algorithm: d.Algorithm, Probabilities: d.Probabilities}
+		cases := make(map[string]*conformanceCase)
+		for _, c := range d.Cases {
+			cases[c.Hash] = c
+		}
+		for _, c := range bd.Cases {
+			if override, ok := cases[c.Hash]; ok {
+				resolved.Cases = append(resolved.Cases, override)
+			} else {
+				resolved.Cases = append(resolved.Cases, &conformanceCase{Key: c.Key, Hash: c.Hash, Case: c.Case})
+			}
+		}
+		positions := make(map[string]uint)
+		for _, p := range d.Positions {
+			positions[p.Position] = p.Case
+		}
+		for _, p := range bd.Positions {
+			c, ok := positions[p.Position]
+			if !ok {
+				c = p.Case
+			}
+			resolved.Positions = append(resolved.Positions, &conformancePosition{p.Position, c})
+		}
+		ret.Doormen = append(ret.Doormen, resolved)
+	}
+	return ret, nil
+}
+
+func generateConformanceDoorman(r *mathrand.Rand, id string, probabilities []string, algorithm int) (*conformanceDoorman, error) {
+	w, err := New(id, getProbs(probabilities...))
+	if err != nil {
+		return nil, err
+	}
+	w.Algorithm = algorithm
+	cd := &conformanceDoorman{Id: id, Algorithm: algorithm, Probabilities: probabilities}
+	for i := 0; i < 50; i++ {
+		key := randomConformanceKey(r)
+		b, err := key.encode()
+		if err != nil {
+			return nil, err
+		}
+		h := w.Hash(b)
+		cd.Cases = append(cd.Cases, &conformanceCase{
+			Key:      key,
+			Hash:     fmt.Sprintf("%016x", h),
+			Position: w.Position(h).RatString(),
+			Case:     w.GetCaseFromData(b),
+		})
+	}
+	for _, p := range conformancePositions {
+		cd.Positions = append(cd.Positions, &conformancePosition{p, w.GetCase(getProbs(p)[0])})
+	}
+	return cd, nil
+}
+
+func runConformanceCorpus(t *testing.T, corpus *conformanceCorpus) {
+	for _, d := range corpus.Doormen {
+		w, err := New(d.Id, getProbs(d.Probabilities...))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		w.Algorithm = d.Algorithm
+		for _, c := range d.Cases {
+			b, err := c.Key.encode()
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			h := w.Hash(b)
+			if hs := fmt.Sprintf("%016x", h); hs != c.Hash {
+				t.Error("bad hash of", d.Id, c.Key, hs)
+			}
+			if c.Position == "" {
+				// a case of the base, whose position depends on the algorithm
+			} else if p, ok := new(big.Rat).SetString(c.Position); !ok {
+				t.Error("bad position", c.Position)
+			} else if !IsEqual(p, w.Position(h)) {
+				t.Error("bad position of", d.Id, c.Key)
+			}
+			if cs := w.GetCaseFromData(b); cs != c.Case {
+				t.Error("bad case of", d.Id, c.Key, cs)
+			}
+		}
+		for _, p := range d.Positions {
+			if c := w.GetCase(getProbs(p.Position)[0]); c != p.Case {
+				t.Error("bad case of", d.Id, "at position", p.Position, c)
+			}
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	if *update {
+		corpus, err := generateConformanceCorpus(conformanceVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.MarshalIndent(corpus, "", "  ")
+		if err := ioutil.WriteFile(conformanceFile(conformanceVersion), append(b, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "v*.json"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(files) == 0 {
+		t.Fatal("no conformance corpus")
+	}
+	for _, file := range files {
+		corpus, err := readConformanceCorpus(file)
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		if corpus.Version < 1 || corpus.Version > conformanceVersion {
+			t.Error(file, "unsupported corpus version", corpus.Version)
+			continue
+		}
+		if corpus, err = resolveConformanceCorpus(corpus); err != nil {
+			t.Fatal(file, err)
+		}
+		runConformanceCorpus(t, corpus)
+	}
+}
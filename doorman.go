@@ -1,16 +1,15 @@
 package doorman
 
 import (
+	"bytes"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
-	"log"
 	"math"
 	"math/big"
-	mathrand "math/rand"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dchest/siphash"
@@ -19,15 +18,39 @@ import (
 
 var ONE *big.Rat = big.NewRat(1, 1)
 
-var rand *mathrand.Rand
+// The bucketing algorithms, that is the ways a hash becomes a position in
+// [0, 1].  An algorithm is never changed once released since it would
+// reassign every key; a new algorithm is added instead and the server opts
+// each doorman into it explicitly.
+const (
+	// AlgorithmV1 reverses the bits of the hash into a float64, truncating
+	// it to 53 bits.  It is the default algorithm.
+	AlgorithmV1 = 1
+	// AlgorithmV2 maps the hash h to the exact rational (h + 1) / 2^64 so
+	// that each case is chosen with exactly its probability, and never when
+	// it is zero.
+	AlgorithmV2 = 2
+)
 
-func initRandomSeed() {
-	kindOfRandomSeed := mathrand.NewSource(time.Now().Unix())
-	rand = mathrand.New(kindOfRandomSeed)
-}
+var twoPow64 = new(big.Int).Lsh(big.NewInt(1), 64)
 
-func init() {
-	initRandomSeed()
+var (
+	ErrBadId                = errors.New("bad doorman id")
+	ErrBadSum               = errors.New("the sum of probabilities cannot be different than 1")
+	ErrForcedCaseOutOfRange = errors.New("forced case out of range")
+	ErrUnsupportedAlgorithm = errors.New("unsupported bucketing algorithm")
+	ErrPrerequisiteCycle    = errors.New("prerequisites of doormen cannot form a cycle")
+
+	errStaleTimestamp = errors.New("stale timestamp")
+)
+
+var rejectionReasons = map[error]string{
+	ErrBadId:                shared.RejectedBadId,
+	ErrBadSum:               shared.RejectedBadSum,
+	ErrForcedCaseOutOfRange: shared.RejectedBadForcedCase,
+	ErrUnsupportedAlgorithm: shared.RejectedBadAlgorithm,
+	ErrPrerequisiteCycle:    shared.RejectedCycle,
+	errStaleTimestamp:       shared.RejectedStaleTimestamp,
 }
 
 func IsEqual(f1, f2 *big.Rat) bool {
@@ -35,11 +58,25 @@ func IsEqual(f1, f2 *big.Rat) bool {
 }
 
 type Doorman struct {
-	Id                  string         // the id of the doorman
-	LastChangeTimestamp int64          // an always increasing int that represent the last time the doorman has beed updated
-	Probabilities       []*big.Rat     //  The probability of each cases.  The sum of probabilities needs to be one
-	wg                  sync.WaitGroup // waitgroup for goroutine safety
-	hashKey             []byte         // the decoded id
+	Id                  string                 // the id of the doorman
+	LastChangeTimestamp int64                  // an always increasing int that represent the last time the doorman has beed updated
+	Probabilities       []*big.Rat             //  The probability of each cases.  The sum of probabilities needs to be one.  Replaced by the updates, read it with CopyProbabilities once subscribed
+	Algorithm           int                    // the bucketing algorithm, AlgorithmV1 when zero
+	Random              RandomSource           // optional, the source of GetRandomCase
+	Store               AssignmentStore        // optional, makes the case of a key sticky across updates
+	Instrumentation     shared.Instrumentation // optional, receives the events of the doorman and of its subscribers
+	Logger              shared.Logger          // optional, receives the log messages of the doorman and of its subscribers
+	mu                  sync.RWMutex           // protects the probabilities, the algorithm, the prerequisites and the kill state against updates
+	prerequisites       []*shared.Prerequisite // evaluated by the registry
+	registry            *Registry              // the registry the doorman belongs to, if any
+	hashKey             []byte                 // the decoded id
+	killed              bool                   // true when the server has forced every case to forcedCase
+	forcedCase          uint                   // the case forced by the server when killed
+	localForcedCase     int64                  // one more than the case forced by the local kill switch, 0 when not killed
+	statusMu            sync.Mutex             // protects the fields bellow
+	lastSeen            time.Time              // the last time an update has been received
+	lastError           error                  // the last error of an update or of a subscriber
+	transport           string                 // the transport of the subscriber
 }
 
 func New(id string, probabilities []*big.Rat) (*Doorman, error) {
@@ -57,9 +94,19 @@ func New(id string, probabilities []*big.Rat) (*Doorman, error) {
 }
 
 func (w *Doorman) Length() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 	return len(w.Probabilities)
 }
 
+// CopyProbabilities returns a copy of the probabilities, safe to read while
+// the doorman is updated.
+func (w *Doorman) CopyProbabilities() []*big.Rat {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return append([]*big.Rat(nil), w.Probabilities...)
+}
+
 func (w *Doorman) UpdateHard(baseURL string) error {
 	r, err := http.Get(baseURL + "/" + w.Id)
 	if err != nil {
@@ -74,25 +121,183 @@ func (w *Doorman) UpdateHard(baseURL string) error {
 	}
 }
 
+func (w *Doorman) instrumentation() shared.Instrumentation {
+	if w.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return w.Instrumentation
+}
+
+func (w *Doorman) logger() shared.Logger {
+	if w.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return w.Logger
+}
+
 func (w *Doorman) Update(wu *shared.DoormanUpdater) error {
-	if wu.Timestamp <= w.LastChangeTimestamp {
+	if r := w.getRegistry(); r != nil {
+		// prerequisites of every doorman of the registry are checked for cycles
+		r.mu.Lock()
+		defer r.mu.Unlock()
+	}
+	w.mu.Lock()
+	err := w.update(wu)
+	w.mu.Unlock()
+	w.recordUpdate(wu, err)
+	if err == nil {
+		w.instrumentation().UpdateAccepted(w.Id)
+		return nil
+	}
+	w.instrumentation().UpdateRejected(w.Id, rejectionReason(err))
+	if err == errStaleTimestamp {
 		return nil
 	}
+	return err
+}
+
+func rejectionReason(err error) string {
+	if reason, ok := rejectionReasons[err]; ok {
+		return reason
+	}
+	return shared.RejectedOther
+}
+
+func (w *Doorman) update(wu *shared.DoormanUpdater) error {
+	if wu.Timestamp <= w.LastChangeTimestamp {
+		return errStaleTimestamp
+	}
+	algorithm, err := w.checkAlgorithm(wu)
+	if err != nil {
+		return err
+	}
+	if w.registry != nil && w.registry.hasCycle(w.Id, wu.Prerequisites, nil) {
+		return ErrPrerequisiteCycle
+	}
+	if err := w.checkProbabilities(wu); err != nil {
+		// the timestamp of an update with bad probabilities is still consumed
+		w.LastChangeTimestamp = wu.Timestamp
+		return err
+	}
+	return w.apply(wu, algorithm)
+}
+
+// check validates wu without modifying the doorman and returns the bucketing
+// algorithm it sets.  The caller holds w.mu.
+func (w *Doorman) check(wu *shared.DoormanUpdater) (int, error) {
+	algorithm, err := w.checkAlgorithm(wu)
+	if err != nil {
+		return 0, err
+	}
+	return algorithm, w.checkProbabilities(wu)
+}
+
+func (w *Doorman) checkAlgorithm(wu *shared.DoormanUpdater) (int, error) {
 	if w.Id != wu.Id {
-		return errors.New("bad doorman id")
+		return 0, ErrBadId
 	}
-	w.wg.Add(1)
-	defer w.wg.Done()
-	w.LastChangeTimestamp = wu.Timestamp
+	algorithm := wu.Algorithm
+	if algorithm == 0 {
+		algorithm = AlgorithmV1
+	}
+	if algorithm != AlgorithmV1 && algorithm != AlgorithmV2 {
+		return 0, ErrUnsupportedAlgorithm
+	}
+	return algorithm, nil
+}
 
+func (w *Doorman) checkProbabilities(wu *shared.DoormanUpdater) error {
+	// the probabilities are optional in a kill message; when absent, the
+	// current ones are kept so the doorman can be revived later without
+	// sending them again.
+	if wu.Killed && len(wu.Probabilities) == 0 {
+		if int(wu.ForcedCase) >= len(w.Probabilities) {
+			return ErrForcedCaseOutOfRange
+		}
+		return nil
+	}
 	if !IsEqual(w.sum(wu.Probabilities), ONE) {
-		return errors.New("the sum of probabilities cannot be different than 1")
+		return ErrBadSum
+	}
+	if wu.Killed && int(wu.ForcedCase) >= len(wu.Probabilities) {
+		return ErrForcedCaseOutOfRange
+	}
+	// the case of the local kill switch must survive the update
+	if c := atomic.LoadInt64(&w.localForcedCase); c > int64(len(wu.Probabilities)) {
+		return ErrForcedCaseOutOfRange
 	}
-	w.Probabilities = wu.Probabilities
-	log.Printf("Updated doorman %v with new probabilities %v with timestamp %v", wu.Id, wu.Probabilities, wu.Timestamp)
 	return nil
 }
 
+// apply sets a checked wu.  The caller holds w.mu.
+func (w *Doorman) apply(wu *shared.DoormanUpdater, algorithm int) error {
+	w.LastChangeTimestamp = wu.Timestamp
+
+	if wu.Reset {
+		if err := w.ResetAssignments(); err != nil {
+			return err
+		}
+	}
+	if len(wu.Probabilities) > 0 || !wu.Killed {
+		w.Probabilities = wu.Probabilities
+	}
+	w.Algorithm = algorithm
+	w.prerequisites = wu.Prerequisites
+	w.killed = wu.Killed
+	if wu.Killed {
+		w.forcedCase = wu.ForcedCase
+		w.logger().Info("doorman killed", "doorman_id", wu.Id, "timestamp", wu.Timestamp, "forced_case", wu.ForcedCase)
+	} else {
+		w.logger().Info("doorman updated", "doorman_id", wu.Id, "timestamp", wu.Timestamp, "probabilities", wu.Probabilities)
+	}
+	return nil
+}
+
+// ResetAssignments forgets the sticky assignments of the doorman, if any.
+func (w *Doorman) ResetAssignments() error {
+	if w.Store == nil {
+		return nil
+	}
+	return w.Store.Reset(w.Id)
+}
+
+// Kill is the local kill switch.  Every case evaluates to c until Revive is
+// called, whatever the server sends and even if the subscriber is disconnected.
+// The updates removing the case c are rejected meanwhile.
+func (w *Doorman) Kill(c uint) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if int(c) >= len(w.Probabilities) {
+		return ErrForcedCaseOutOfRange
+	}
+	atomic.StoreInt64(&w.localForcedCase, int64(c)+1)
+	return nil
+}
+
+// Revive turns off the local kill switch.
+func (w *Doorman) Revive() {
+	atomic.StoreInt64(&w.localForcedCase, 0)
+}
+
+// ForcedCase returns the case every evaluation is forced to, either by the
+// local kill switch or by the server, and whether the doorman is killed at all.
+// The local kill switch has precedence.
+func (w *Doorman) ForcedCase() (uint, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.forced()
+}
+
+func (w *Doorman) forced() (uint, bool) {
+	if c := atomic.LoadInt64(&w.localForcedCase); c > 0 {
+		return uint(c - 1), true
+	}
+	if w.killed {
+		return w.forcedCase, true
+	}
+	return 0, false
+}
+
 func (w *Doorman) sum(prob []*big.Rat) *big.Rat {
 	ret := big.NewRat(0, 1)
 	for _, p := range prob {
@@ -102,6 +307,8 @@ func (w *Doorman) sum(prob []*big.Rat) *big.Rat {
 }
 
 func (w *Doorman) Validate() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 	if len(w.Probabilities) == 0 {
 		return errors.New("not initiated")
 	}
@@ -112,8 +319,35 @@ func (w *Doorman) Validate() error {
 	return nil
 }
 
+func (w *Doorman) evaluated(c uint) uint {
+	w.instrumentation().Evaluated(w.Id, c)
+	return c
+}
+
+// evaluatedKey reports the evaluation of data, with its key to a
+// KeyedInstrumentation.
+func (w *Doorman) evaluatedKey(data [][]byte, c uint) uint {
+	if i, ok := w.instrumentation().(shared.KeyedInstrumentation); ok {
+		i.EvaluatedKey(w.Id, string(bytes.Join(data, nil)), c)
+		return c
+	}
+	return w.evaluated(c)
+}
+
 func (w *Doorman) GetCase(choosenRandomPosition *big.Rat) uint {
-	w.wg.Wait()
+	return w.evaluated(w.getCase(choosenRandomPosition))
+}
+
+func (w *Doorman) getCase(choosenRandomPosition *big.Rat) uint {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.caseAt(choosenRandomPosition)
+}
+
+func (w *Doorman) caseAt(choosenRandomPosition *big.Rat) uint {
+	if c, killed := w.forced(); killed {
+		return c
+	}
 	var prob = big.NewRat(0, 1)
 	for i, p := range w.Probabilities {
 		prob = new(big.Rat).Add(prob, p)
@@ -136,6 +370,22 @@ func (w *Doorman) GenerateRandomProbabilityFromInteger(data uint64) *big.Rat {
 	return rat.SetFloat64(ret)
 }
 
+// Position returns the position in [0, 1] of hash h with the bucketing
+// algorithm of the doorman.
+func (w *Doorman) Position(h uint64) *big.Rat {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.position(h)
+}
+
+func (w *Doorman) position(h uint64) *big.Rat {
+	if w.Algorithm == AlgorithmV2 {
+		num := new(big.Int).Add(new(big.Int).SetUint64(h), big.NewInt(1))
+		return new(big.Rat).SetFrac(num, twoPow64)
+	}
+	return w.GenerateRandomProbabilityFromInteger(h)
+}
+
 func (w *Doorman) Hash(data ...[]byte) uint64 {
 	h := siphash.New(w.hashKey)
 	for _, datum := range data {
@@ -144,23 +394,87 @@ func (w *Doorman) Hash(data ...[]byte) uint64 {
 	return h.Sum64()
 }
 
+// GetCaseFromData returns the case of data.  When the doorman belongs to a
+// registry, it returns the control case 0 unless its prerequisites are met.
 func (w *Doorman) GetCaseFromData(data ...[]byte) uint {
-	random := w.GenerateRandomProbabilityFromInteger(w.Hash(data...))
-	return w.GetCase(random)
+	if c, killed := w.ForcedCase(); killed {
+		return w.evaluatedKey(data, c)
+	}
+	if r := w.getRegistry(); r != nil && !r.prerequisitesMet(w, data) {
+		return w.evaluatedKey(data, 0)
+	}
+	return w.evaluatedKey(data, w.assign(data))
+}
+
+// assign returns the case of data ignoring the kill state and the
+// prerequisites.
+func (w *Doorman) assign(data [][]byte) uint {
+	if w.Store != nil {
+		return w.getStickyCase(data...)
+	}
+	return w.getCaseFromHash(data...)
+}
+
+func (w *Doorman) getCaseFromHash(data ...[]byte) uint {
+	h := w.Hash(data...)
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.caseAt(w.position(h))
+}
+
+// getStickyCase returns the case previously assigned to data, if it still
+// exists, and assigns the hashed case otherwise.  Store errors are logged and
+// fall back on hashing.
+func (w *Doorman) getStickyCase(data ...[]byte) uint {
+	key := string(bytes.Join(data, nil))
+	if c, ok, err := w.Store.Get(w.Id, key); err != nil {
+		w.logger().Error("cannot get assignment", "doorman_id", w.Id, "error", err)
+	} else if ok && int(c) < w.Length() {
+		return c
+	}
+	c := w.getCaseFromHash(data...)
+	if err := w.Store.Set(w.Id, key, c); err != nil {
+		w.logger().Error("cannot set assignment", "doorman_id", w.Id, "error", err)
+	}
+	return c
 }
 
 func (w *Doorman) GetCaseFromString(data string) uint {
 	return w.GetCaseFromData([]byte(data))
 }
 
-// not yet in spect
-func (w *Doorman) getCaseFromInt(data int) uint {
-	b := make([]byte, 10)
-	binary.LittleEndian.PutUint64(b, uint64(data))
-	return w.GetCaseFromData(b)
+func (w *Doorman) GetCaseFromInt(data int64) uint {
+	return w.GetCaseFromData(EncodeInt(data))
+}
+
+func (w *Doorman) GetCaseFromUint64(data uint64) uint {
+	return w.GetCaseFromData(EncodeUint64(data))
+}
+
+func (w *Doorman) GetCaseFromUUID(data [16]byte) uint {
+	return w.GetCaseFromData(EncodeUUID(data))
+}
+
+// GetCaseFromKey returns the case of a composite key made of ordered fields,
+// for example EncodeInt(userId) and []byte(deviceId).
+func (w *Doorman) GetCaseFromKey(fields ...[]byte) uint {
+	return w.GetCaseFromData(EncodeKey(fields...))
+}
+
+func (w *Doorman) random() RandomSource {
+	if w.Random == nil {
+		return defaultRandomSource
+	}
+	return w.Random
 }
 
 func (w *Doorman) GetRandomCase() uint {
-	r := rand.Float64()
+	w.mu.RLock()
+	algorithm := w.Algorithm
+	w.mu.RUnlock()
+	if algorithm == AlgorithmV2 {
+		return w.GetCase(w.Position(w.random().Uint64()))
+	}
+	r := w.random().Float64()
 	return w.GetCase(new(big.Rat).SetFloat64(r))
 }
@@ -110,10 +110,10 @@ func TestGetCase(t *testing.T) {
 func TestGetCaseCoroutineSafety(t *testing.T) {
 	w := newDoorman(getProbs("1/4", "2/4", "1/4"))
 	i := 0
-	w.wg.Add(1)
+	w.mu.Lock() // as an update in progress
 	go func() {
 		i++
-		w.wg.Done()
+		w.mu.Unlock()
 	}()
 	w.GetCase(ZERO)
 	if i != 1 {
@@ -121,6 +121,43 @@ func TestGetCaseCoroutineSafety(t *testing.T) {
 	}
 }
 
+// TestConcurrentUpdates is meant for the race detector: the evaluations
+// never see a partial update.
+func TestConcurrentUpdates(t *testing.T) {
+	w := newDoorman(getProbs("1/2", "1/2"))
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := int64(1); i <= 200; i++ {
+			wu := &shared.DoormanUpdater{Id: w.Id, Timestamp: i, Probabilities: getProbs("1/4", "1/4", "1/2"), Algorithm: AlgorithmV2}
+			if i%2 == 0 {
+				wu.Probabilities, wu.Algorithm = getProbs("1/2", "1/2"), AlgorithmV1
+			}
+			if i%10 == 0 {
+				wu.Killed, wu.Reset = true, true
+			}
+			if err := w.Update(wu); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		key := strconv.Itoa(i)
+		for _, c := range []uint{w.GetCaseFromString(key), w.GetRandomCase(), w.GetCase(ZERO)} {
+			if c > 2 {
+				t.Fatal("case out of range", c)
+			}
+		}
+		w.Status()
+		w.CopyProbabilities()
+	}
+}
+
 func TestGenerateRandomProbabilityFromBitSlice(t *testing.T) {
 	w := new(Doorman)
 	assertIsEqual(t, big.NewRat(1, 2), w.GenerateRandomProbabilityFromInteger(1))
@@ -181,6 +218,256 @@ func TestUpdateProbabilities(t *testing.T) {
 
 }
 
+func TestUpdateKilled(t *testing.T) {
+	w := &Doorman{Id: oid, Probabilities: getProbs("1/2", "1/2")}
+	m := &shared.DoormanUpdater{Timestamp: 1, Id: oid, Killed: true, ForcedCase: 1}
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	} else if c, killed := w.ForcedCase(); !killed || c != 1 {
+		t.Error("doorman should be killed on case 1")
+	} else if !reflect.DeepEqual(w.Probabilities, getProbs("1/2", "1/2")) {
+		t.Error("probabilities should be kept")
+	}
+	if c := w.GetCase(ZERO); c != 1 {
+		t.Error("expected 1 but received", c)
+	}
+
+	m = &shared.DoormanUpdater{Timestamp: 2, Id: oid, Killed: true, ForcedCase: 2}
+	if err := w.Update(m); err == nil {
+		t.Error("should received an error")
+	}
+
+	m = &shared.DoormanUpdater{Timestamp: 3, Id: oid, Probabilities: getProbs("1/2", "1/2")}
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	} else if _, killed := w.ForcedCase(); killed {
+		t.Error("doorman should be revived")
+	}
+}
+
+func TestLocalKillSwitch(t *testing.T) {
+	w := newDoorman(getProbs("1/4", "2/4", "1/4"))
+	if err := w.Kill(3); err == nil {
+		t.Error("should received an error")
+	}
+	if err := w.Kill(2); err != nil {
+		t.Error(err)
+	}
+	if c := w.GetCaseFromString("Azərbaycan.."); c != 2 {
+		t.Error("expected 2 but received", c)
+	}
+	if c := w.GetRandomCase(); c != 2 {
+		t.Error("expected 2 but received", c)
+	}
+
+	m := &shared.DoormanUpdater{Timestamp: 1, Id: w.Id, Killed: true}
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	}
+	if c := w.GetCase(ONE); c != 2 {
+		t.Error("local kill switch should have precedence, received", c)
+	}
+
+	w.Revive()
+	if c := w.GetCase(ONE); c != 0 {
+		t.Error("expected the server forced case 0 but received", c)
+	}
+}
+
+func TestLocalKillSwitchShrinkingUpdate(t *testing.T) {
+	w := newDoorman(getProbs("1/4", "2/4", "1/4"))
+	if err := w.Kill(2); err != nil {
+		t.Fatal(err)
+	}
+	m := &shared.DoormanUpdater{Timestamp: 1, Id: w.Id, Probabilities: getProbs("1/2", "1/2")}
+	if err := w.Update(m); err != ErrForcedCaseOutOfRange {
+		t.Error("an update removing the locally forced case should be rejected", err)
+	}
+	if c := w.GetCaseFromString("foo"); c != 2 || w.Length() != 3 {
+		t.Error("the doorman should keep its cases", c, w.Length())
+	}
+
+	w.Revive()
+	m.Timestamp = 2
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	}
+	if err := w.Kill(2); err != ErrForcedCaseOutOfRange {
+		t.Error("should received an error", err)
+	}
+}
+
+type mapStore map[string]uint
+
+func (s mapStore) Get(doormanId, key string) (uint, bool, error) {
+	c, ok := s[doormanId+key]
+	return c, ok, nil
+}
+
+func (s mapStore) Set(doormanId, key string, c uint) error {
+	s[doormanId+key] = c
+	return nil
+}
+
+func (s mapStore) Reset(doormanId string) error {
+	for k := range s {
+		delete(s, k)
+	}
+	return nil
+}
+
+func TestStickyAssignments(t *testing.T) {
+	w := newDoorman(getProbs("1/4", "1/2", "1/4"))
+	w.Store = mapStore{}
+	if c := w.GetCaseFromString("Հայաստան.."); c != 2 {
+		t.Error("expected 2 but received", c)
+	}
+
+	m := &shared.DoormanUpdater{Timestamp: 1, Id: w.Id, Probabilities: getProbs("1/2", "1/2")}
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	}
+	if c := w.GetCaseFromString("Հայաստան.."); c != 1 {
+		t.Error("case 2 does not exist anymore, expected 1 but received", c)
+	}
+
+	m = &shared.DoormanUpdater{Timestamp: 2, Id: w.Id, Probabilities: getProbs("1", "0")}
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	}
+	if c := w.GetCaseFromString("Հայաստան.."); c != 1 {
+		t.Error("assignment should be sticky, expected 1 but received", c)
+	}
+
+	m = &shared.DoormanUpdater{Timestamp: 3, Id: w.Id, Probabilities: getProbs("1", "0"), Reset: true}
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	}
+	if c := w.GetCaseFromString("Հայաստան.."); c != 0 {
+		t.Error("assignment should be reset, expected 0 but received", c)
+	}
+}
+
+type recordingInstrumentation struct {
+	shared.NopInstrumentation
+	evaluated []uint
+	accepted  int
+	rejected  []string
+}
+
+func (i *recordingInstrumentation) Evaluated(doormanId string, c uint) {
+	i.evaluated = append(i.evaluated, c)
+}
+
+func (i *recordingInstrumentation) UpdateAccepted(doormanId string) {
+	i.accepted++
+}
+
+func (i *recordingInstrumentation) UpdateRejected(doormanId, reason string) {
+	i.rejected = append(i.rejected, reason)
+}
+
+func TestInstrumentation(t *testing.T) {
+	w := newDoorman(getProbs("1/4", "1/2", "1/4"))
+	i := new(recordingInstrumentation)
+	w.Instrumentation = i
+
+	w.GetCaseFromString("Հայաստան..")
+	w.GetCase(ZERO)
+	if !reflect.DeepEqual(i.evaluated, []uint{2, 0}) {
+		t.Error("bad evaluations", i.evaluated)
+	}
+
+	w.Update(&shared.DoormanUpdater{Timestamp: 1, Id: w.Id, Probabilities: getProbs("1/2", "1/2")})
+	w.Update(&shared.DoormanUpdater{Timestamp: 1, Id: w.Id, Probabilities: getProbs("1/2", "1/2")})
+	w.Update(&shared.DoormanUpdater{Timestamp: 2, Id: oid, Probabilities: getProbs("1/2", "1/2")})
+	w.Update(&shared.DoormanUpdater{Timestamp: 3, Id: w.Id, Probabilities: getProbs("1/2", "1/4")})
+	w.Update(&shared.DoormanUpdater{Timestamp: 4, Id: w.Id, Killed: true, ForcedCase: 2})
+	if i.accepted != 1 {
+		t.Error("bad number of accepted updates", i.accepted)
+	}
+	expt := []string{shared.RejectedStaleTimestamp, shared.RejectedBadId, shared.RejectedBadSum, shared.RejectedBadForcedCase}
+	if !reflect.DeepEqual(i.rejected, expt) {
+		t.Error("bad rejection reasons", i.rejected)
+	}
+}
+
+type recordingLogger struct {
+	shared.NopLogger
+	messages []string
+	args     [][]interface{}
+}
+
+func (l *recordingLogger) Info(msg string, args ...interface{}) {
+	l.messages = append(l.messages, msg)
+	l.args = append(l.args, args)
+}
+
+func TestLogger(t *testing.T) {
+	w := newDoorman(getProbs("1/4", "1/2", "1/4"))
+	l := new(recordingLogger)
+	w.Logger = l
+	w.Update(&shared.DoormanUpdater{Timestamp: 1, Id: w.Id, Probabilities: getProbs("1/2", "1/2")})
+	if !reflect.DeepEqual(l.messages, []string{"doorman updated"}) {
+		t.Fatal("bad messages", l.messages)
+	}
+	if args := l.args[0]; args[0] != "doorman_id" || args[1] != w.Id || args[2] != "timestamp" || args[3] != int64(1) {
+		t.Error("bad fields", args)
+	}
+}
+
+func TestUpdateAlgorithm(t *testing.T) {
+	w := newDoorman(getProbs("1/2", "1/2"))
+	m := &shared.DoormanUpdater{Timestamp: 1, Id: w.Id, Probabilities: getProbs("0", "1"), Algorithm: AlgorithmV2}
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	} else if w.Algorithm != AlgorithmV2 {
+		t.Error("bad algorithm", w.Algorithm)
+	}
+	if c := w.GetCase(w.Position(0)); c != 1 {
+		t.Error("a case of probability zero should never be chosen, received", c)
+	}
+
+	m = &shared.DoormanUpdater{Timestamp: 2, Id: w.Id, Probabilities: getProbs("0", "1"), Algorithm: 3}
+	if err := w.Update(m); err != ErrUnsupportedAlgorithm {
+		t.Error("should received an unsupported algorithm error", err)
+	} else if w.Algorithm != AlgorithmV2 {
+		t.Error("bad algorithm", w.Algorithm)
+	}
+
+	m = &shared.DoormanUpdater{Timestamp: 3, Id: w.Id, Probabilities: getProbs("0", "1")}
+	if err := w.Update(m); err != nil {
+		t.Error(err)
+	} else if w.Algorithm != AlgorithmV1 {
+		t.Error("an update without algorithm should use the legacy algorithm", w.Algorithm)
+	}
+	if c := w.GetCase(w.Position(0)); c != 0 {
+		t.Error("legacy algorithm should be kept bit for bit, received", c)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	w := newDoorman(getProbs("1/2", "1/2"))
+	assertIsEqual(t, w.GenerateRandomProbabilityFromInteger(5), w.Position(5))
+
+	w.Algorithm = AlgorithmV2
+	assertIsEqual(t, new(big.Rat).SetFrac(big.NewInt(6), twoPow64), w.Position(5))
+	assertIsEqual(t, ONE, w.Position(math.MaxUint64))
+}
+
+func TestGetRandomCaseV2(t *testing.T) {
+	n := 10000
+	wab := newDoorman(getProbs("1/2", "1/2"))
+	wab.Algorithm = AlgorithmV2
+	var sum = 0
+	for i := 0; i < n; i++ {
+		sum += int(wab.GetRandomCase())
+	}
+	if err := IsExtremeBinomialResult(sum, float64(n), 0.5); err != nil {
+		t.Error(err)
+	}
+}
+
 func randomBytes(n int) []byte {
 	b := make([]byte, n)
 	for i := range b {
@@ -270,23 +557,6 @@ func TestConsistencyOfDoormenWhenProbabilityChanges(t *testing.T) {
 	}
 }
 
-// bellow test is not yet in doorman's specification.  It is not clear how
-//we want to hash numbers because of the many number types in many programming
-// language
-func _TestGetCaseFromInt(t *testing.T) {
-	w := newDoorman(getProbs("1/4", "1/2", "1/4"))
-	expts := map[int]uint{
-		2:   2,
-		13:  2,
-		195: 1,
-	}
-	for data, expt := range expts {
-		if res := w.getCaseFromInt(data); res != expt {
-			t.Error("incorrect results for: ", data, res)
-		}
-	}
-}
-
 func BenchmarkGetCaseFromData(b *testing.B) {
 	var data = randomBytes(1024 * 1024)
 	w := newDoorman(getProbs("10/100", "40/100", "40/100", "5/100", "5/100"))
@@ -0,0 +1,68 @@
+// Package doormantest contains tools to test doormen and the code using them.
+package doormantest
+
+import (
+	"strconv"
+
+	"github.com/didiercrunch/doorman"
+	"github.com/didiercrunch/doorman/internal/stats"
+)
+
+// Result is the outcome of a chi-squared goodness of fit test of the cases
+// drawn from a doorman against its probabilities.
+type Result struct {
+	N                int
+	Observed         []int     // the number of draws of each case
+	Expected         []float64 // the expected number of draws of each case
+	ChiSquared       float64
+	DegreesOfFreedom int
+	PValue           float64 // the probability of a fit at least that bad if the bucketing is right
+}
+
+// Fits returns true unless the p-value is bellow the significance level
+// alpha, for example 0.001.
+func (r *Result) Fits(alpha float64) bool {
+	return r.PValue >= alpha
+}
+
+// Check draws n cases with draw and tests their distribution against the
+// probabilities of w when the check starts.  The cases drawn out of them, w
+// having gained cases meanwhile, are expected never and fail the fit.
+func Check(w *doorman.Doorman, n int, draw func(i int) uint) *Result {
+	probabilities := w.CopyProbabilities()
+	r := &Result{N: n, Observed: make([]int, len(probabilities)), Expected: make([]float64, len(probabilities))}
+	for i, p := range probabilities {
+		f, _ := p.Float64()
+		r.Expected[i] = f * float64(n)
+	}
+	for i := 0; i < n; i++ {
+		c := int(draw(i))
+		for len(r.Observed) <= c {
+			r.Observed = append(r.Observed, 0)
+			r.Expected = append(r.Expected, 0)
+		}
+		r.Observed[c]++
+	}
+	r.ChiSquared, r.DegreesOfFreedom = stats.ChiSquared(r.Observed, r.Expected)
+	r.PValue = stats.ChiSquaredPValue(r.ChiSquared, r.DegreesOfFreedom)
+	return r
+}
+
+// CheckGetCaseFromString tests the distribution of GetCaseFromString over
+// the n keys key(0), ..., key(n - 1).  When key is nil, the keys are the
+// decimal representations of 0, ..., n - 1.
+func CheckGetCaseFromString(w *doorman.Doorman, n int, key func(i int) string) *Result {
+	if key == nil {
+		key = strconv.Itoa
+	}
+	return Check(w, n, func(i int) uint {
+		return w.GetCaseFromString(key(i))
+	})
+}
+
+// CheckGetRandomCase tests the distribution of n calls to GetRandomCase.
+func CheckGetRandomCase(w *doorman.Doorman, n int) *Result {
+	return Check(w, n, func(i int) uint {
+		return w.GetRandomCase()
+	})
+}
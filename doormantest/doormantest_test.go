@@ -0,0 +1,69 @@
+package doormantest
+
+import (
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/didiercrunch/doorman"
+)
+
+func newDoorman(t *testing.T, algorithm int, probabilities ...*big.Rat) *doorman.Doorman {
+	id := base64.URLEncoding.EncodeToString([]byte("0123456789abcdef"))
+	w, err := doorman.New(id, probabilities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Algorithm = algorithm
+	w.Random = doorman.NewSeededRandomSource(7)
+	return w
+}
+
+const alpha = 0.001
+
+func TestCheckGetCaseFromString(t *testing.T) {
+	for _, algorithm := range []int{doorman.AlgorithmV1, doorman.AlgorithmV2} {
+		w := newDoorman(t, algorithm, big.NewRat(1, 4), big.NewRat(1, 2), big.NewRat(1, 4))
+		r := CheckGetCaseFromString(w, 20000, nil)
+		if !r.Fits(alpha) {
+			t.Error("bad distribution with algorithm", algorithm, r.Observed, r.PValue)
+		}
+		if r.DegreesOfFreedom != 2 || r.Expected[1] != 10000 {
+			t.Error("bad result", r)
+		}
+	}
+}
+
+func TestCheckGetRandomCase(t *testing.T) {
+	w := newDoorman(t, doorman.AlgorithmV1, big.NewRat(1, 10), big.NewRat(0, 1), big.NewRat(9, 10))
+	r := CheckGetRandomCase(w, 20000)
+	if !r.Fits(alpha) {
+		t.Error("bad distribution", r.Observed, r.PValue)
+	}
+	if r.Observed[1] != 0 || r.DegreesOfFreedom != 1 {
+		t.Error("bad result", r)
+	}
+}
+
+func TestCheckDetectsBias(t *testing.T) {
+	w := newDoorman(t, doorman.AlgorithmV1, big.NewRat(1, 2), big.NewRat(1, 2))
+	r := Check(w, 10000, func(i int) uint {
+		if i%100 < 47 {
+			return 0
+		}
+		return 1
+	})
+	if r.Fits(alpha) {
+		t.Error("a 47/53 split should not fit", r.PValue)
+	}
+}
+
+func TestCheckCaseOutOfRange(t *testing.T) {
+	w := newDoorman(t, doorman.AlgorithmV1, big.NewRat(1, 2), big.NewRat(1, 2))
+	r := Check(w, 100, func(i int) uint {
+		return uint(i % 3) // as if w gained a case during the check
+	})
+	if r.Fits(alpha) || len(r.Observed) != 3 || r.Observed[2] != 33 {
+		t.Error("the cases out of the probabilities should fail the fit", r.Observed, r.PValue)
+	}
+}
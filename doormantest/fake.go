@@ -0,0 +1,229 @@
+package doormantest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"sync"
+
+	"github.com/didiercrunch/doorman"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// Evaluation is the evaluation of a doorman with a key.  The key of
+// GetCaseFromData is the concatenation of its data, so the key of
+// GetCaseFromKey is the encoding of EncodeKey.  The evaluations of
+// GetRandomCase and GetCase have no key.
+type Evaluation struct {
+	DoormanId string
+	Key       string
+	Random    bool // true for GetRandomCase and GetCase
+}
+
+// Fake makes the doormen of the tests of an application.  Its doormen are
+// real doormen, with ids derived from names, whose cases can be forced and
+// whose evaluations are recorded.  Fake is also the subscriber of its
+// doormen: Publish updates them without any network.
+type Fake struct {
+	mu          sync.Mutex
+	forced      map[string]uint            // the cases forced for every key by doorman id
+	forcedKeys  map[string]map[string]uint // the cases forced by doorman id and key
+	evaluations []Evaluation
+	updates     map[string][]shared.UpdateHandlerFunc
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		forced:     make(map[string]uint),
+		forcedKeys: make(map[string]map[string]uint),
+		updates:    make(map[string][]shared.UpdateHandlerFunc),
+	}
+}
+
+// Id returns the id of the doorman named name.
+func Id(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return base64.URLEncoding.EncodeToString(h[:16])
+}
+
+// Uniform returns the probabilities of n equally likely cases.
+func Uniform(n int) []*big.Rat {
+	ret := make([]*big.Rat, n)
+	for i := range ret {
+		ret[i] = big.NewRat(1, int64(n))
+	}
+	return ret
+}
+
+// New returns the doorman named name with n equally likely cases, added to f.
+// It panics when n is not positive.
+func (f *Fake) New(name string, n int) *doorman.Doorman {
+	w, err := doorman.New(Id(name), Uniform(n))
+	if err != nil {
+		panic("doormantest: " + err.Error())
+	}
+	f.Add(w)
+	return w
+}
+
+// Add records the evaluations of w, forces its cases and subscribes it to f.
+// The Store and Instrumentation of w, when set, are wrapped so the cases not
+// forced are still assigned by the store and the instrumentation still
+// receives the evaluations; set them before Add.
+func (f *Fake) Add(w *doorman.Doorman) {
+	w.Store = fakeStore{f, w.Store}
+	instrumentation := w.Instrumentation
+	if instrumentation == nil {
+		instrumentation = shared.NopInstrumentation{}
+	}
+	w.Instrumentation = fakeInstrumentation{instrumentation, f}
+	w.Subscribe(f)
+}
+
+// Force forces the case c for every key of w.  It returns
+// doorman.ErrForcedCaseOutOfRange when w has no case c; a case forced before an
+// update removing it is ignored.  The random cases are not forced.
+func (f *Fake) Force(w *doorman.Doorman, c uint) error {
+	if int(c) >= w.Length() {
+		return doorman.ErrForcedCaseOutOfRange
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.forced[w.Id] = c
+	return nil
+}
+
+// ForceKey forces the case c for key, before the case forced by Force.  Like
+// Force, it returns doorman.ErrForcedCaseOutOfRange when w has no case c.
+func (f *Fake) ForceKey(w *doorman.Doorman, key string, c uint) error {
+	if int(c) >= w.Length() {
+		return doorman.ErrForcedCaseOutOfRange
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.forcedKeys[w.Id] == nil {
+		f.forcedKeys[w.Id] = make(map[string]uint)
+	}
+	f.forcedKeys[w.Id][key] = c
+	return nil
+}
+
+// Unforce forgets the cases forced for w.
+func (f *Fake) Unforce(w *doorman.Doorman) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.forced, w.Id)
+	delete(f.forcedKeys, w.Id)
+}
+
+// Evaluations returns the evaluations of every doorman of f, in order.
+func (f *Fake) Evaluations() []Evaluation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Evaluation(nil), f.evaluations...)
+}
+
+// Keys returns the keys w has been evaluated with, in order, without the
+// random evaluations.
+func (f *Fake) Keys(w *doorman.Doorman) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ret []string
+	for _, e := range f.evaluations {
+		if e.DoormanId == w.Id && !e.Random {
+			ret = append(ret, e.Key)
+		}
+	}
+	return ret
+}
+
+// Subscribe makes f a doorman.Subscriber.
+func (f *Fake) Subscribe(doormanId string, update shared.UpdateHandlerFunc) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates[doormanId] = append(f.updates[doormanId], update)
+	return nil
+}
+
+// Publish hands wu to the doormen subscribed to f, as a live update would.
+func (f *Fake) Publish(wu *shared.DoormanUpdater) error {
+	f.mu.Lock()
+	updates := f.updates[wu.Id]
+	f.mu.Unlock()
+	for _, update := range updates {
+		if err := update(wu); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetProbabilities publishes the update of the probabilities of w, following
+// its last update.
+func (f *Fake) SetProbabilities(w *doorman.Doorman, probabilities ...*big.Rat) error {
+	timestamp := w.Status().LastChangeTimestamp + 1
+	return f.Publish(&shared.DoormanUpdater{Id: w.Id, Timestamp: timestamp, Probabilities: probabilities})
+}
+
+// record appends an evaluation.
+func (f *Fake) record(e Evaluation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.evaluations = append(f.evaluations, e)
+}
+
+// fakeStore is the doorman.AssignmentStore returning the forced cases.  The
+// other cases come from store, if any, and are hashed otherwise.
+type fakeStore struct {
+	f     *Fake
+	store doorman.AssignmentStore
+}
+
+func (s fakeStore) Get(doormanId, key string) (uint, bool, error) {
+	s.f.mu.Lock()
+	c, ok := s.f.forcedKeys[doormanId][key]
+	if !ok {
+		c, ok = s.f.forced[doormanId]
+	}
+	s.f.mu.Unlock()
+	if ok || s.store == nil {
+		return c, ok, nil
+	}
+	return s.store.Get(doormanId, key)
+}
+
+func (s fakeStore) Set(doormanId, key string, c uint) error {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Set(doormanId, key, c)
+}
+
+func (s fakeStore) Reset(doormanId string) error {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Reset(doormanId)
+}
+
+// fakeInstrumentation records the evaluations, whether the doorman is killed
+// or its prerequisites are met or not, before handing them to the
+// instrumentation of the doorman.
+type fakeInstrumentation struct {
+	shared.Instrumentation
+	f *Fake
+}
+
+func (i fakeInstrumentation) Evaluated(doormanId string, c uint) {
+	i.f.record(Evaluation{DoormanId: doormanId, Random: true})
+	i.Instrumentation.Evaluated(doormanId, c)
+}
+
+func (i fakeInstrumentation) EvaluatedKey(doormanId, key string, c uint) {
+	i.f.record(Evaluation{DoormanId: doormanId, Key: key})
+	if keyed, ok := i.Instrumentation.(shared.KeyedInstrumentation); ok {
+		keyed.EvaluatedKey(doormanId, key, c)
+	} else {
+		i.Instrumentation.Evaluated(doormanId, c)
+	}
+}
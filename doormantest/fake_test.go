@@ -0,0 +1,172 @@
+package doormantest
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/didiercrunch/doorman"
+	"github.com/didiercrunch/doorman/assignmentstore"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestFakeForce(t *testing.T) {
+	f := NewFake()
+	w := f.New("checkout", 3)
+	if w.Id != Id("checkout") || w.Length() != 3 {
+		t.Fatal("bad doorman", w.Id, w.Length())
+	}
+	free := make(map[string]uint)
+	for _, key := range []string{"a", "b", "c"} {
+		free[key] = w.GetCaseFromString(key)
+	}
+
+	f.Force(w, 2)
+	f.ForceKey(w, "a", 1)
+	if err := f.ForceKey(w, "b", 7); err != doorman.ErrForcedCaseOutOfRange {
+		t.Error("should received a forced case out of range error", err)
+	}
+	if err := f.Force(w, 3); err != doorman.ErrForcedCaseOutOfRange {
+		t.Error("should received a forced case out of range error", err)
+	}
+	if c := w.GetCaseFromString("a"); c != 1 {
+		t.Error("a should be forced to 1", c)
+	}
+	if c := w.GetCaseFromString("b"); c != free["b"] {
+		t.Error("b should not be forced", c)
+	}
+	if c := w.GetCaseFromString("c"); c != 2 {
+		t.Error("c should be forced to 2", c)
+	}
+
+	f.Unforce(w)
+	for key, expected := range free {
+		if c := w.GetCaseFromString(key); c != expected {
+			t.Error("the case of", key, "should not be forced anymore", c)
+		}
+	}
+}
+
+func TestFakeEvaluations(t *testing.T) {
+	f := NewFake()
+	a, b := f.New("a", 2), f.New("b", 2)
+	a.GetCaseFromString("x")
+	b.GetCaseFromKey([]byte("y"), []byte("z"))
+	a.GetCaseFromString("z")
+	b.GetRandomCase()
+
+	expected := []Evaluation{
+		{DoormanId: a.Id, Key: "x"},
+		{DoormanId: b.Id, Key: string(doorman.EncodeKey([]byte("y"), []byte("z")))},
+		{DoormanId: a.Id, Key: "z"},
+		{DoormanId: b.Id, Random: true},
+	}
+	if evaluations := f.Evaluations(); !reflect.DeepEqual(evaluations, expected) {
+		t.Error("bad evaluations", evaluations)
+	}
+	if keys := f.Keys(a); !reflect.DeepEqual(keys, []string{"x", "z"}) {
+		t.Error("bad keys", keys)
+	}
+}
+
+func TestFakeUpdates(t *testing.T) {
+	f := NewFake()
+	w := f.New("a", 2)
+	if err := f.SetProbabilities(w, big.NewRat(0, 1), big.NewRat(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if c := w.GetCaseFromString("x"); c != 1 {
+		t.Error("the update should be applied", c)
+	}
+	if err := f.SetProbabilities(w, big.NewRat(1, 2)); err == nil {
+		t.Error("a bad update should be rejected")
+	}
+
+	if err := f.Publish(&shared.DoormanUpdater{Id: w.Id, Timestamp: 10, Killed: true}); err != nil {
+		t.Fatal(err)
+	}
+	if c, killed := w.ForcedCase(); !killed || c != 0 {
+		t.Error("the doorman should be killed", c, killed)
+	}
+	if err := f.SetProbabilities(w, big.NewRat(1, 1), big.NewRat(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if s := w.Status(); s.Killed || s.LastChangeTimestamp != 11 {
+		t.Error("the probabilities should follow the published update", s.Killed, s.LastChangeTimestamp)
+	}
+	if err := f.Publish(&shared.DoormanUpdater{Id: Id("unknown"), Timestamp: 1}); err != nil {
+		t.Error("an update without doorman should be ignored", err)
+	}
+}
+
+func TestFakeAdd(t *testing.T) {
+	f := NewFake()
+	w, err := doorman.New(Id("a"), Uniform(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := assignmentstore.NewLRU(10)
+	store.Set(w.Id, "x", 1)
+	w.Store = store
+	f.Add(w)
+
+	if c := w.GetCaseFromString("x"); c != 1 {
+		t.Error("the case of the store should be kept", c)
+	}
+	f.Force(w, 0)
+	if c := w.GetCaseFromString("x"); c != 0 {
+		t.Error("x should be forced to 0", c)
+	}
+	f.Unforce(w)
+	if c := w.GetCaseFromString("x"); c != 1 {
+		t.Error("the forced case should not be stored", c)
+	}
+	c := w.GetCaseFromString("y")
+	if stored, ok, _ := store.Get(w.Id, "y"); !ok || stored != c {
+		t.Error("the case of y should be stored", stored, ok)
+	}
+	if keys := f.Keys(w); !reflect.DeepEqual(keys, []string{"x", "x", "x", "y"}) {
+		t.Error("bad keys", keys)
+	}
+}
+
+type countingInstrumentation struct {
+	shared.NopInstrumentation
+	evaluations int
+}
+
+func (i *countingInstrumentation) Evaluated(doormanId string, c uint) {
+	i.evaluations++
+}
+
+func TestFakeEvaluationsShortcut(t *testing.T) {
+	f := NewFake()
+	gate := f.New("gate", 2)
+	feature, err := doorman.New(Id("feature"), Uniform(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &countingInstrumentation{}
+	feature.Instrumentation = i
+	f.Add(feature)
+	r := doorman.NewRegistry()
+	r.Add(gate)
+	r.Add(feature)
+	// the feature is only on for the keys in the case 1 of the gate, no key
+	f.Force(gate, 0)
+	if err := f.Publish(&shared.DoormanUpdater{Id: feature.Id, Timestamp: 1, Probabilities: Uniform(2), Prerequisites: []*shared.Prerequisite{{DoormanId: gate.Id, Cases: []uint{1}}}}); err != nil {
+		t.Fatal(err)
+	}
+	feature.GetCaseFromString("x")
+	feature.Kill(1)
+	feature.GetCaseFromString("y")
+	if keys := f.Keys(feature); !reflect.DeepEqual(keys, []string{"x", "y"}) {
+		t.Error("the evaluations with unmet prerequisites or killed should be recorded", keys)
+	}
+	if len(f.Keys(gate)) != 0 {
+		t.Error("resolving a prerequisite is not an evaluation", f.Keys(gate))
+	}
+	if i.evaluations != 2 {
+		t.Error("the instrumentation of the doorman should receive the evaluations", i.evaluations)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 
 	"github.com/didiercrunch/doorman"
+	"github.com/didiercrunch/doorman/shared"
 )
 
 func getProbs(probs ...float64) []*big.Rat {
@@ -27,6 +28,7 @@ func init() {
 	if wab, err = doorman.New("XapIHlp_JIxFReURP8Ouyg==", getProbs(1, 0, 0)); err != nil {
 		panic(err)
 	}
+	wab.Logger = shared.SlogLogger(nil)
 	if err := wab.Subscriber("http://localhost:1999"); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,148 @@
+// Package grpcsubscriber streams the doorman updates over the server
+// streaming rpc of doorman.proto.
+package grpcsubscriber
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const transport = "grpc"
+
+var errStreamClosed = errors.New("stream closed by the server")
+
+type GrpcSubscriber struct {
+	Target          string
+	DialOptions     []grpc.DialOption      // optional, insecure credentials by default
+	Backoff         time.Duration          // optional, the delay before reopening a failed stream, a second by default
+	Instrumentation shared.Instrumentation // optional
+	Logger          shared.Logger          // optional
+	mu              sync.Mutex
+	closers         []func() // close the subscriptions
+}
+
+func (s *GrpcSubscriber) Transport() string {
+	return transport
+}
+
+func (s *GrpcSubscriber) instrumentation() shared.Instrumentation {
+	if s.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+func (s *GrpcSubscriber) logger() shared.Logger {
+	if s.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return s.Logger
+}
+
+func (s *GrpcSubscriber) backoff() time.Duration {
+	if s.Backoff == 0 {
+		return time.Second
+	}
+	return s.Backoff
+}
+
+func (s *GrpcSubscriber) dialOptions() []grpc.DialOption {
+	if len(s.DialOptions) == 0 {
+		return []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return s.DialOptions
+}
+
+func (s *GrpcSubscriber) Subscribe(doormanId string, update shared.UpdateHandlerFunc) error {
+	return s.SubscribeMany([]string{doormanId}, update)
+}
+
+// SubscribeMany streams the updates of every doorman of doormanIds to update.
+// A failed stream is reopened, resuming every doorman after the sequence
+// number of its last update received.
+func (s *GrpcSubscriber) SubscribeMany(doormanIds []string, update shared.UpdateHandlerFunc) error {
+	conn, err := grpc.NewClient(s.Target, s.dialOptions()...)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.closers = append(s.closers, func() {
+		cancel()
+		conn.Close()
+	})
+	s.mu.Unlock()
+	go s.run(ctx, conn, &SubscribeRequest{DoormanIds: doormanIds, ResumeFrom: make(map[string]uint64)}, update)
+	return nil
+}
+
+// Close ends every subscription.
+func (s *GrpcSubscriber) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, close := range s.closers {
+		close()
+	}
+	s.closers = nil
+}
+
+func (s *GrpcSubscriber) run(ctx context.Context, conn *grpc.ClientConn, req *SubscribeRequest, update shared.UpdateHandlerFunc) {
+	doormanId := strings.Join(req.DoormanIds, ",")
+	failing := false
+	received := func() {
+		if failing {
+			s.logger().Info("stream reopened", "doorman_id", doormanId, "transport", transport, "resume_from", req.ResumeFrom)
+			s.instrumentation().SubscriberReconnected(doormanId, transport)
+			failing = false
+		}
+	}
+	for {
+		err := s.stream(ctx, conn, req, received, update)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger().Error("stream failed", "doorman_id", doormanId, "transport", transport, "error", err)
+		s.instrumentation().SubscriberError(doormanId, transport, err)
+		failing = true
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.backoff()):
+		}
+	}
+}
+
+// stream receives the updates of req until the stream fails.
+func (s *GrpcSubscriber) stream(ctx context.Context, conn *grpc.ClientConn, req *SubscribeRequest, received func(), update shared.UpdateHandlerFunc) error {
+	stream, err := conn.NewStream(ctx, &serviceDesc.Streams[0], subscribeMethod, grpc.ForceCodec(grpcCodec{}))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		wu := new(shared.DoormanUpdater)
+		if err := stream.RecvMsg(wu); err == io.EOF {
+			return errStreamClosed
+		} else if err != nil {
+			return err
+		}
+		received()
+		req.ResumeFrom[wu.Id] = wu.Sequence
+		if err := update(wu); err != nil {
+			s.logger().Error("cannot update doorman with received data", "doorman_id", wu.Id, "transport", transport, "error", err)
+		}
+	}
+}
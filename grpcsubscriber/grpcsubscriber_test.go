@@ -0,0 +1,175 @@
+package grpcsubscriber
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testService sends the updates after the resumed sequence numbers of their
+// doormen, failing the first stream after two updates.
+type testService struct {
+	mu       sync.Mutex
+	requests []SubscribeRequest
+	updates  []*shared.DoormanUpdater
+}
+
+func (s *testService) Subscribe(req *SubscribeRequest, stream UpdateStream) error {
+	s.mu.Lock()
+	s.requests = append(s.requests, *req)
+	first := len(s.requests) == 1
+	s.mu.Unlock()
+	sent := 0
+	for _, wu := range s.updates {
+		if wu.Sequence <= req.ResumeFrom[wu.Id] {
+			continue
+		}
+		if first && sent == 2 {
+			return errors.New("server failure")
+		}
+		if err := stream.Send(wu); err != nil {
+			return err
+		}
+		sent++
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// startTestServer serves srv in process and returns the subscriber dialing
+// it.
+func startTestServer(t *testing.T, srv Service) *GrpcSubscriber {
+	lis := bufconn.Listen(1 << 16)
+	s := grpc.NewServer(ServerCodec())
+	RegisterService(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+	return &GrpcSubscriber{
+		Target:      "passthrough:///bufnet",
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials())},
+		Backoff:     10 * time.Millisecond,
+	}
+}
+
+type recordingInstrumentation struct {
+	shared.NopInstrumentation
+	mu         sync.Mutex
+	errors     int
+	reconnects int
+}
+
+func (i *recordingInstrumentation) SubscriberError(doormanId, transport string, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.errors++
+}
+
+func (i *recordingInstrumentation) SubscriberReconnected(doormanId, transport string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.reconnects++
+}
+
+func TestSubscribeResume(t *testing.T) {
+	srv := &testService{}
+	for i := 0; i < 4; i++ {
+		id := "a"
+		if i%2 == 1 {
+			id = "b"
+		}
+		srv.updates = append(srv.updates, &shared.DoormanUpdater{Id: id, Timestamp: int64(i), Sequence: uint64(i/2 + 1), Probabilities: []*big.Rat{big.NewRat(1, 1)}})
+	}
+	sub := startTestServer(t, srv)
+	i := new(recordingInstrumentation)
+	sub.Instrumentation = i
+	defer sub.Close()
+
+	received := make(chan *shared.DoormanUpdater)
+	if err := sub.SubscribeMany([]string{"a", "b"}, func(wu *shared.DoormanUpdater) error {
+		received <- wu
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range srv.updates {
+		select {
+		case wu := <-received:
+			if wu.Id != expected.Id || wu.Timestamp != expected.Timestamp || wu.Probabilities[0].Cmp(big.NewRat(1, 1)) != 0 {
+				t.Error("received", wu, "but expected", expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for update", expected.Timestamp)
+		}
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	expected := []SubscribeRequest{{DoormanIds: []string{"a", "b"}}, {DoormanIds: []string{"a", "b"}, ResumeFrom: map[string]uint64{"a": 1, "b": 1}}}
+	if !reflect.DeepEqual(srv.requests, expected) {
+		t.Error("bad requests", srv.requests)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.errors != 1 || i.reconnects != 1 {
+		t.Error("bad instrumentation", i.errors, i.reconnects)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	req := &SubscribeRequest{DoormanIds: []string{"a", "b", "c"}, ResumeFrom: map[string]uint64{"a": 300, "b": 0}}
+	data, err := grpcCodec{}.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := new(SubscribeRequest)
+	if err := (grpcCodec{}).Unmarshal(data, received); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req, received) {
+		t.Error("received", received, "but expected", req)
+	}
+	if _, err := (grpcCodec{}).Marshal("foo"); err != errUnknownMessage {
+		t.Error("expected an unknown message", err)
+	}
+}
+
+func TestServerCodecOtherServices(t *testing.T) {
+	lis := bufconn.Listen(1 << 16)
+	s := grpc.NewServer(ServerCodec())
+	RegisterService(s, &testService{})
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	defer s.Stop()
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+	conn, err := grpc.NewClient("passthrough:///bufnet", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal("the other services should keep the protobuf codec", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Error("bad status", resp.Status)
+	}
+}
@@ -0,0 +1,128 @@
+package grpcsubscriber
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+const defaultHistory = 1024
+
+// GrpcPublisher is the Service streaming the updaters published.  It numbers
+// the updaters of every doorman from 1 and keeps the last History updates, so
+// a reopened stream resumes every doorman without missing any update while
+// they are kept and receives its current state otherwise.
+type GrpcPublisher struct {
+	History   int // optional, the updates kept for the resumed streams, 1024 by default
+	seq       shared.Sequencer
+	mu        sync.Mutex
+	published uint64 // the number of updates published, ordering the streams
+	history   []publication
+	latest    map[string]publication
+	dropped   map[string]uint64 // the sequence number of the last update of every doorman out of the history
+	changed   chan struct{}     // closed by the next publication
+}
+
+// publication is a numbered updater and its rank among every publication.
+type publication struct {
+	rank uint64
+	wu   *shared.DoormanUpdater
+}
+
+func (p *GrpcPublisher) historySize() int {
+	if p.History == 0 {
+		return defaultHistory
+	}
+	return p.History
+}
+
+// init initializes p.  The caller holds p.mu.
+func (p *GrpcPublisher) init() {
+	if p.latest == nil {
+		p.latest = make(map[string]publication)
+		p.dropped = make(map[string]uint64)
+		p.changed = make(chan struct{})
+	}
+}
+
+// Publish sends a copy of wu, numbered with the next sequence number of its
+// doorman, to the streams of its doorman.
+func (p *GrpcPublisher) Publish(wu *shared.DoormanUpdater) error {
+	return p.seq.Publish(wu, p.publish)
+}
+
+func (p *GrpcPublisher) publish(wu *shared.DoormanUpdater) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.init()
+	p.published++
+	pub := publication{p.published, wu}
+	p.history = append(p.history, pub)
+	if len(p.history) > p.historySize() {
+		p.dropped[p.history[0].wu.Id] = p.history[0].wu.Sequence
+		p.history = p.history[1:]
+	}
+	p.latest[wu.Id] = pub
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return nil
+}
+
+// updates returns, in the order of their publication, the updates of doormen
+// after the sequence number of after of their doorman, or their latest update
+// when the history does not hold them all anymore, and the channel closed by
+// the next publication.
+func (p *GrpcPublisher) updates(doormen map[string]bool, after map[string]uint64) ([]*shared.DoormanUpdater, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.init()
+	var pubs []publication
+	resumed := make(map[string]bool) // the doormen resumed from the history
+	for id := range doormen {
+		latest, ok := p.latest[id]
+		switch {
+		case !ok || after[id] == latest.wu.Sequence:
+		case after[id] == 0 || after[id] > latest.wu.Sequence || p.dropped[id] > after[id]:
+			pubs = append(pubs, latest)
+		default:
+			resumed[id] = true
+		}
+	}
+	for _, pub := range p.history {
+		if resumed[pub.wu.Id] && pub.wu.Sequence > after[pub.wu.Id] {
+			pubs = append(pubs, pub)
+		}
+	}
+	sort.Slice(pubs, func(i, j int) bool {
+		return pubs[i].rank < pubs[j].rank
+	})
+	ret := make([]*shared.DoormanUpdater, len(pubs))
+	for i, pub := range pubs {
+		ret[i] = pub.wu
+	}
+	return ret, p.changed
+}
+
+func (p *GrpcPublisher) Subscribe(req *SubscribeRequest, stream UpdateStream) error {
+	doormen := make(map[string]bool)
+	after := make(map[string]uint64)
+	for _, id := range req.DoormanIds {
+		doormen[id] = true
+		after[id] = req.ResumeFrom[id]
+	}
+	for {
+		updates, changed := p.updates(doormen, after)
+		for _, wu := range updates {
+			if err := stream.Send(wu); err != nil {
+				return err
+			}
+			after[wu.Id] = wu.Sequence
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package grpcsubscriber
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func sequences(updates []*shared.DoormanUpdater) string {
+	var ret []uint64
+	for _, wu := range updates {
+		ret = append(ret, wu.Sequence)
+	}
+	return fmt.Sprint(ret)
+}
+
+func TestGrpcPublisherHistory(t *testing.T) {
+	p := &GrpcPublisher{History: 4}
+	for i, id := range []string{"a", "b", "a", "c", "a", "b", "a"} {
+		p.Publish(&shared.DoormanUpdater{Id: id, Timestamp: int64(i)})
+	}
+	// the history holds c1, a3, b2 and a4
+	doormen := map[string]bool{"a": true, "b": true}
+	for _, test := range []struct {
+		after    map[string]uint64
+		expected string
+	}{
+		{nil, "[2 4]"}, // the current state
+		{map[string]uint64{"a": 1, "b": 1}, "[2 4]"},   // a2 is out of the history
+		{map[string]uint64{"a": 2, "b": 1}, "[3 2 4]"}, // resumed
+		{map[string]uint64{"a": 3, "b": 2}, "[4]"},     // resumed
+		{map[string]uint64{"a": 4, "b": 2}, "[]"},      // up to date
+		{map[string]uint64{"a": 9, "b": 2}, "[4]"},     // from another publisher
+		{map[string]uint64{"a": 4, "c": 1}, "[2]"},     // the current state of b
+		{map[string]uint64{"a": 2, "b": 9}, "[3 2 4]"}, // b from another publisher
+	} {
+		updates, _ := p.updates(doormen, test.after)
+		if sequences(updates) != test.expected {
+			t.Error("after", test.after, "received", sequences(updates), "but expected", test.expected)
+		}
+	}
+}
+
+func TestGrpcPublisher(t *testing.T) {
+	p := new(GrpcPublisher)
+	p.Publish(&shared.DoormanUpdater{Id: "a", Timestamp: 1})
+	p.Publish(&shared.DoormanUpdater{Id: "a", Timestamp: 2})
+	sub := startTestServer(t, p)
+	defer sub.Close()
+
+	received := make(chan *shared.DoormanUpdater, 10)
+	if err := sub.SubscribeMany([]string{"a", "b"}, func(wu *shared.DoormanUpdater) error {
+		received <- wu
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		// published while the stream opens or once open
+		p.Publish(&shared.DoormanUpdater{Id: "c", Timestamp: 3})
+		p.Publish(&shared.DoormanUpdater{Id: "b", Timestamp: 4})
+	}()
+	for _, expected := range []int64{2, 4} {
+		select {
+		case wu := <-received:
+			if wu.Timestamp != expected {
+				t.Error("received", wu.Timestamp, "but expected", expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for", expected)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package grpcsubscriber
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	serviceName      = "doorman.Doorman"
+	subscribeMethod  = "/" + serviceName + "/Subscribe"
+	subscribeStreams = "Subscribe"
+)
+
+type SubscribeRequest struct {
+	DoormanIds []string
+	ResumeFrom map[string]uint64 // the sequence number of the last update received by doorman id, the current state of the others
+}
+
+// UpdateStream sends the updaters of a subscription.
+type UpdateStream interface {
+	Send(wu *shared.DoormanUpdater) error
+	Context() context.Context
+}
+
+// Service is the server side of doorman.proto.  Subscribe sends the updates of
+// every doorman of req after its sequence number in req.ResumeFrom, or its
+// current state when it cannot, until the context of stream is done.
+type Service interface {
+	Subscribe(req *SubscribeRequest, stream UpdateStream) error
+}
+
+// RegisterService serves srv on s.  The server must use the codec of
+// ServerCodec.
+func RegisterService(s *grpc.Server, srv Service) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// ServerCodec returns the server option encoding the messages of
+// doorman.proto.  The messages of the other services of the server, generated
+// by protoc, are encoded as by the default codec.
+func ServerCodec() grpc.ServerOption {
+	return grpc.ForceServerCodec(grpcCodec{})
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Service)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    subscribeStreams,
+		Handler:       subscribeHandler,
+		ServerStreams: true,
+	}},
+	Metadata: "grpcsubscriber/doorman.proto",
+}
+
+type updateStream struct {
+	grpc.ServerStream
+}
+
+func (s updateStream) Send(wu *shared.DoormanUpdater) error {
+	return s.SendMsg(wu)
+}
+
+func subscribeHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(SubscribeRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(Service).Subscribe(req, updateStream{stream})
+}
+
+var errUnknownMessage = errors.New("unknown grpc message")
+
+// grpcCodec encodes the messages of doorman.proto, named like the protobuf
+// codec of grpc for the wire compatibility with generated clients, and falls
+// back on protobuf for the generated messages.
+type grpcCodec struct{}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case *shared.DoormanUpdater:
+		return codec.Protobuf.Marshal(m)
+	case *SubscribeRequest:
+		var b []byte
+		for _, id := range m.DoormanIds {
+			b = protowire.AppendTag(b, 1, protowire.BytesType)
+			b = protowire.AppendString(b, id)
+		}
+		ids := make([]string, 0, len(m.ResumeFrom))
+		for id := range m.ResumeFrom {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			// a map entry is a message of the key 1 and the value 2
+			var entry []byte
+			entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+			entry = protowire.AppendString(entry, id)
+			entry = protowire.AppendTag(entry, 2, protowire.VarintType)
+			entry = protowire.AppendVarint(entry, m.ResumeFrom[id])
+			b = protowire.AppendTag(b, 2, protowire.BytesType)
+			b = protowire.AppendBytes(b, entry)
+		}
+		return b, nil
+	case proto.Message:
+		return proto.Marshal(m)
+	}
+	return nil, errUnknownMessage
+}
+
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *shared.DoormanUpdater:
+		return codec.Protobuf.Unmarshal(data, m)
+	case *SubscribeRequest:
+		*m = SubscribeRequest{}
+		for len(data) > 0 {
+			num, typ, n := protowire.ConsumeTag(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				var id string
+				id, n = protowire.ConsumeString(data)
+				m.DoormanIds = append(m.DoormanIds, id)
+			case num == 2 && typ == protowire.BytesType:
+				var entry []byte
+				entry, n = protowire.ConsumeBytes(data)
+				if n >= 0 {
+					if err := m.unmarshalResumeFrom(entry); err != nil {
+						return err
+					}
+				}
+			default:
+				n = protowire.ConsumeFieldValue(num, typ, data)
+			}
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	}
+	return errUnknownMessage
+}
+
+// unmarshalResumeFrom adds the map entry data to m.ResumeFrom.
+func (m *SubscribeRequest) unmarshalResumeFrom(data []byte) error {
+	var id string
+	var sequence uint64
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			id, n = protowire.ConsumeString(data)
+		case num == 2 && typ == protowire.VarintType:
+			sequence, n = protowire.ConsumeVarint(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	if m.ResumeFrom == nil {
+		m.ResumeFrom = make(map[string]uint64)
+	}
+	m.ResumeFrom[id] = sequence
+	return nil
+}
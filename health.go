@@ -0,0 +1,76 @@
+package doorman
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthHandler is an http.Handler reporting the status of the registered
+// doormen.  It answers 503 when a required doorman is invalid, has never been
+// updated by the server, or has not been updated for longer than MaxAge.  It
+// is meant to back a readiness probe.
+type HealthHandler struct {
+	MaxAge  time.Duration // 0 never considers a doorman stale
+	mu      sync.Mutex
+	doormen []*Doorman
+	require map[*Doorman]bool
+}
+
+type DoormanHealth struct {
+	*Status
+	Required bool `json:"required"`
+	Healthy  bool `json:"healthy"`
+}
+
+type Health struct {
+	Healthy bool             `json:"healthy"`
+	Doormen []*DoormanHealth `json:"doormen"`
+}
+
+func NewHealthHandler(maxAge time.Duration) *HealthHandler {
+	return &HealthHandler{MaxAge: maxAge, require: make(map[*Doorman]bool)}
+}
+
+// Register adds w to the report.  When required is true, the handler is
+// unhealthy as long as w is.
+func (h *HealthHandler) Register(w *Doorman, required bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.require[w]; !ok {
+		h.doormen = append(h.doormen, w)
+	}
+	h.require[w] = required
+}
+
+func (h *HealthHandler) isHealthy(s *Status, now time.Time) bool {
+	if !s.Valid || !s.Initialized {
+		return false
+	}
+	return h.MaxAge <= 0 || now.Sub(s.LastSeen) <= h.MaxAge
+}
+
+func (h *HealthHandler) Health(now time.Time) *Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ret := &Health{Healthy: true, Doormen: make([]*DoormanHealth, len(h.doormen))}
+	for i, w := range h.doormen {
+		s := w.Status()
+		dh := &DoormanHealth{Status: s, Required: h.require[w], Healthy: h.isHealthy(s, now)}
+		if dh.Required && !dh.Healthy {
+			ret.Healthy = false
+		}
+		ret.Doormen[i] = dh
+	}
+	return ret
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	health := h.Health(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
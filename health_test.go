@@ -0,0 +1,65 @@
+package doorman
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func getHealth(t *testing.T, h http.Handler) (int, *Health) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	ret := new(Health)
+	if err := json.Unmarshal(w.Body.Bytes(), ret); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, ret
+}
+
+func TestHealthHandler(t *testing.T) {
+	required := newDoorman(getProbs("1/2", "1/2"))
+	optional := newDoorman(getProbs("1/2", "1/2"))
+	optional.Id = oid
+	h := NewHealthHandler(time.Minute)
+	h.Register(required, true)
+	h.Register(optional, false)
+
+	if code, health := getHealth(t, h); code != http.StatusServiceUnavailable || health.Healthy {
+		t.Error("an uninitialized required doorman should be unhealthy", code)
+	} else if len(health.Doormen) != 2 || health.Doormen[0].Initialized || !health.Doormen[0].Valid {
+		t.Error("bad doormen health", health.Doormen)
+	}
+
+	required.Update(&shared.DoormanUpdater{Timestamp: 5, Id: required.Id, Probabilities: getProbs("1/4", "3/4")})
+	if code, health := getHealth(t, h); code != http.StatusOK || !health.Healthy {
+		t.Error("uninitialized optional doorman should not matter", code)
+	} else if d := health.Doormen[0]; !d.Healthy || d.LastChangeTimestamp != 5 {
+		t.Error("bad doorman health", d)
+	} else if d := health.Doormen[1]; d.Healthy || d.Required {
+		t.Error("bad doorman health", d)
+	}
+
+	if health := h.Health(time.Now().Add(2 * time.Minute)); health.Healthy {
+		t.Error("a stale required doorman should be unhealthy")
+	}
+}
+
+func TestStatusLastError(t *testing.T) {
+	w := newDoorman(getProbs("1/2", "1/2"))
+	w.Update(&shared.DoormanUpdater{Timestamp: 1, Id: w.Id, Probabilities: getProbs("1/2", "1/4")})
+	if s := w.Status(); s.LastError != ErrBadSum.Error() || s.Initialized {
+		t.Error("bad status", s)
+	}
+	w.subscriberInstrumentation().SubscriberError(w.Id, "http", ErrBadId)
+	if s := w.Status(); s.LastError != ErrBadId.Error() {
+		t.Error("bad status", s)
+	}
+	w.Update(&shared.DoormanUpdater{Timestamp: 2, Id: w.Id, Probabilities: getProbs("1/2", "1/2")})
+	if s := w.Status(); s.LastError != "" || !s.Initialized {
+		t.Error("bad status", s)
+	}
+}
@@ -3,31 +3,102 @@ package httpsubscriber
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/didiercrunch/doorman/codec"
 	"github.com/didiercrunch/doorman/shared"
-	"log"
+	"io"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 )
 
+const transport = "http"
+
 type HttpSubscriber struct {
-	Url      string
-	HartBeat time.Duration
+	Url             string
+	HartBeat        time.Duration
+	Instrumentation shared.Instrumentation // optional
+	Logger          shared.Logger          // optional
+	Codecs          []codec.Codec          // optional, the accepted codecs by preference, JSON by default
+	failing         bool                   // true when the last poll failed
+}
+
+func (s *HttpSubscriber) instrumentation() shared.Instrumentation {
+	if s.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+func (s *HttpSubscriber) Transport() string {
+	return transport
+}
+
+func (s *HttpSubscriber) logger() shared.Logger {
+	if s.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return s.Logger
+}
+
+// accept returns the Accept header listing the codecs by preference.
+func (s *HttpSubscriber) accept() string {
+	if len(s.Codecs) == 0 {
+		return codec.JSON.ContentType()
+	}
+	mediaRanges := make([]string, len(s.Codecs))
+	for i, c := range s.Codecs {
+		mediaRanges[i] = c.ContentType()
+		if i > 0 {
+			mediaRanges[i] += fmt.Sprintf(";q=%.1f", math.Max(1-0.1*float64(i), 0.1))
+		}
+	}
+	return strings.Join(mediaRanges, ", ")
 }
 
 func (s *HttpSubscriber) GetDoormanUpdater() (*shared.DoormanUpdater, error) {
-	resp, err := http.Get(s.Url)
+	req, err := http.NewRequest("GET", s.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", s.accept())
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
-	} else if resp.StatusCode != 200 {
-		return nil, errors.New("bad http status when GETting update, " + resp.Status)
 	}
 	defer resp.Body.Close()
-	de := json.NewDecoder(resp.Body)
+	if resp.StatusCode != 200 {
+		return nil, errors.New("bad http status when GETting update, " + resp.Status)
+	}
+	// servers predating the codecs always send JSON, whatever their content type
+	c, err := codec.ByContentType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		c = codec.JSON
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 	ret := new(shared.DoormanUpdater)
-	err = de.Decode(ret)
+	err = c.Unmarshal(data, ret)
 	return ret, err
 }
 
+// StatusUrl returns the url of the status of the doorman doormanId on the
+// server serverUrl.
+func StatusUrl(serverUrl, doormanId string) string {
+	return serverUrl + statusPrefix + doormanId + statusSuffix
+}
+
+// Resync returns the ResyncFunc of the push transports fetching the status of
+// the doormen on the server serverUrl.
+func Resync(serverUrl string) shared.ResyncFunc {
+	return func(doormanId string) (*shared.DoormanUpdater, error) {
+		return (&HttpSubscriber{Url: StatusUrl(serverUrl, doormanId)}).GetDoormanUpdater()
+	}
+}
+
 func (s *HttpSubscriber) callUpdateHandlerFunction(f shared.UpdateHandlerFunc, data []byte) error {
 	wu := &shared.DoormanUpdater{}
 	if err := json.Unmarshal(data, wu); err != nil {
@@ -41,12 +112,24 @@ func (s *HttpSubscriber) Subscribe(abtestId string, update shared.UpdateHandlerF
 	go func() {
 		c := time.Tick(s.HartBeat)
 		for _ = range c {
-			if du, err := s.GetDoormanUpdater(); err != nil {
-				log.Printf("error retrieving doorman %v \n%v\n", abtestId, err)
-			} else {
-				update(du)
-			}
+			s.poll(abtestId, update)
 		}
 	}()
 	return nil
 }
+
+func (s *HttpSubscriber) poll(abtestId string, update shared.UpdateHandlerFunc) {
+	du, err := s.GetDoormanUpdater()
+	if err != nil {
+		s.logger().Error("cannot retrieve doorman", "doorman_id", abtestId, "transport", transport, "error", err)
+		s.instrumentation().SubscriberError(abtestId, transport, err)
+		s.failing = true
+		return
+	}
+	if s.failing {
+		s.logger().Info("doorman retrieved again", "doorman_id", abtestId, "transport", transport)
+		s.instrumentation().SubscriberReconnected(abtestId, transport)
+		s.failing = false
+	}
+	update(du)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/didiercrunch/doorman/codec"
 	"github.com/didiercrunch/doorman/shared"
 )
 
@@ -31,9 +32,9 @@ func TestCallUpdateHandlerFunction(t *testing.T) {
 
 func MockEndpoint(w http.ResponseWriter, r *http.Request) {
 	du := &shared.DoormanUpdater{
-		"b64",
-		897987,
-		[]*big.Rat{big.NewRat(1, 4), big.NewRat(3, 4)},
+		Id:            "b64",
+		Timestamp:     897987,
+		Probabilities: []*big.Rat{big.NewRat(1, 4), big.NewRat(3, 4)},
 	}
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(du); err != nil {
@@ -54,3 +55,67 @@ func TestGetDoormanUpdater(t *testing.T) {
 	}
 
 }
+
+type recordingInstrumentation struct {
+	shared.NopInstrumentation
+	errors     int
+	reconnects int
+}
+
+func (i *recordingInstrumentation) SubscriberError(doormanId, transport string, err error) {
+	i.errors++
+}
+
+func (i *recordingInstrumentation) SubscriberReconnected(doormanId, transport string) {
+	i.reconnects++
+}
+
+func TestPollInstrumentation(t *testing.T) {
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		MockEndpoint(w, r)
+	}))
+	defer ts.Close()
+
+	i := new(recordingInstrumentation)
+	s := &HttpSubscriber{Url: ts.URL, Instrumentation: i}
+	updates := 0
+	update := func(m *shared.DoormanUpdater) error {
+		updates++
+		return nil
+	}
+	s.poll("b64", update)
+	s.poll("b64", update)
+	fail = false
+	s.poll("b64", update)
+	s.poll("b64", update)
+	if i.errors != 2 || i.reconnects != 1 || updates != 2 {
+		t.Error("bad instrumentation", i.errors, i.reconnects, updates)
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept"); accept != "application/x-protobuf, application/json;q=0.9" {
+			t.Error("bad accept header", accept)
+		}
+		data, err := codec.Protobuf.Marshal(&shared.DoormanUpdater{Id: "b64", Timestamp: 3, Probabilities: []*big.Rat{big.NewRat(1, 1)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", codec.Protobuf.ContentType())
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	s := &HttpSubscriber{Url: ts.URL, Codecs: []codec.Codec{codec.Protobuf, codec.JSON}}
+	if d, err := s.GetDoormanUpdater(); err != nil {
+		t.Fatal(err)
+	} else if d.Id != "b64" || d.Timestamp != 3 || len(d.Probabilities) != 1 {
+		t.Error("bad updater", d)
+	}
+}
@@ -0,0 +1,111 @@
+package httpsubscriber
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+const (
+	statusPrefix = "/api/doormen/"
+	statusSuffix = "/status"
+	snapshotPath = "/api/snapshot"
+)
+
+// HttpPublisher serves the last updater published of every doorman at
+// /api/doormen/<id>/status, in the codec preferred by the Accept header, and
+// the snapshot of all of them at /api/snapshot.
+type HttpPublisher struct {
+	mu      sync.RWMutex
+	version int64
+	doormen map[string]*shared.DoormanUpdater
+}
+
+func (p *HttpPublisher) Publish(wu *shared.DoormanUpdater) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.doormen == nil {
+		p.doormen = make(map[string]*shared.DoormanUpdater)
+	}
+	p.doormen[wu.Id] = wu
+	p.version++
+	return nil
+}
+
+func (p *HttpPublisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == snapshotPath {
+		p.serveSnapshot(w)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, statusPrefix) || !strings.HasSuffix(r.URL.Path, statusSuffix) {
+		http.NotFound(w, r)
+		return
+	}
+	doormanId := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, statusPrefix), statusSuffix)
+	p.mu.RLock()
+	wu, ok := p.doormen[doormanId]
+	p.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	c := negotiate(r.Header.Get("Accept"))
+	data, err := c.Marshal(wu)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", c.ContentType())
+	w.Write(data)
+}
+
+func (p *HttpPublisher) serveSnapshot(w http.ResponseWriter) {
+	p.mu.RLock()
+	snapshot := &shared.Snapshot{Version: p.version}
+	for _, wu := range p.doormen {
+		snapshot.Doormen = append(snapshot.Doormen, wu)
+	}
+	p.mu.RUnlock()
+	sort.Slice(snapshot.Doormen, func(i, j int) bool {
+		return snapshot.Doormen[i].Id < snapshot.Doormen[j].Id
+	})
+	w.Header().Set("Content-Type", codec.JSON.ContentType())
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// negotiate returns the known codec of the highest quality in accept, the
+// first one listed among equals, and JSON when none is known.
+func negotiate(accept string) codec.Codec {
+	ret, best := codec.JSON, 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		c, err := codec.ByContentType(mediaType)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if params["q"] != "" {
+			if q, err = strconv.ParseFloat(params["q"], 64); err != nil {
+				continue
+			}
+		}
+		if q > best {
+			ret, best = c, q
+		}
+	}
+	return ret
+}
@@ -0,0 +1,62 @@
+package httpsubscriber
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestNegotiate(t *testing.T) {
+	for accept, expected := range map[string]codec.Codec{
+		"":                    codec.JSON,
+		"text/html":           codec.JSON,
+		"application/msgpack": codec.MsgPack,
+		"application/json, application/msgpack;q=0.9":    codec.JSON,
+		"application/json;q=0.5, application/x-protobuf": codec.Protobuf,
+	} {
+		if c := negotiate(accept); c != expected {
+			t.Error("negotiated", c.Name(), "for", accept)
+		}
+	}
+}
+
+func TestHttpPublisher(t *testing.T) {
+	p := new(HttpPublisher)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+	p.Publish(&shared.DoormanUpdater{Id: "foo", Timestamp: 1})
+	p.Publish(&shared.DoormanUpdater{Id: "foo", Timestamp: 2, Probabilities: []*big.Rat{big.NewRat(1, 3), big.NewRat(2, 3)}})
+	p.Publish(&shared.DoormanUpdater{Id: "bar", Timestamp: 3})
+
+	for _, codecs := range [][]codec.Codec{nil, {codec.MsgPack}, {codec.Protobuf, codec.JSON}} {
+		s := &HttpSubscriber{Url: ts.URL + "/api/doormen/foo/status", Codecs: codecs}
+		wu, err := s.GetDoormanUpdater()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wu.Id != "foo" || wu.Timestamp != 2 || wu.Probabilities[1].Cmp(big.NewRat(2, 3)) != 0 {
+			t.Error("bad updater", wu)
+		}
+	}
+	if _, err := (&HttpSubscriber{Url: ts.URL + "/api/doormen/baz/status"}).GetDoormanUpdater(); err == nil {
+		t.Error("expected an error for an unpublished doorman")
+	}
+
+	snapshot, err := (&SnapshotSubscriber{Url: ts.URL + "/api/snapshot"}).GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != 3 || len(snapshot.Doormen) != 2 || snapshot.Doormen[0].Id != "bar" || snapshot.Doormen[1].Timestamp != 2 {
+		t.Error("bad snapshot", snapshot)
+	}
+
+	if resp, err := http.Post(ts.URL+"/api/snapshot", "", nil); err != nil {
+		t.Fatal(err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Error("bad status", resp.Status)
+	}
+}
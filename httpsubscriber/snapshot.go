@@ -0,0 +1,99 @@
+package httpsubscriber
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/didiercrunch/doorman/shared"
+	"net/http"
+	"time"
+)
+
+// snapshotId is the doorman id of the errors and reconnections of a
+// SnapshotSubscriber, which serves every doorman.
+const snapshotId = "*"
+
+// SnapshotSubscriber polls a single url serving the snapshot of every doorman
+// instead of one url per doorman.
+type SnapshotSubscriber struct {
+	Url             string
+	HartBeat        time.Duration          // optional, five seconds by default
+	Instrumentation shared.Instrumentation // optional
+	Logger          shared.Logger          // optional
+	failing         bool                   // true when the last poll failed
+}
+
+func (s *SnapshotSubscriber) hartBeat() time.Duration {
+	if s.HartBeat <= 0 {
+		return 5 * time.Second
+	}
+	return s.HartBeat
+}
+
+func (s *SnapshotSubscriber) instrumentation() shared.Instrumentation {
+	if s.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+func (s *SnapshotSubscriber) logger() shared.Logger {
+	if s.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return s.Logger
+}
+
+func (s *SnapshotSubscriber) Transport() string {
+	return transport
+}
+
+func (s *SnapshotSubscriber) GetSnapshot() (*shared.Snapshot, error) {
+	resp, err := http.Get(s.Url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New("bad http status when GETting snapshot, " + resp.Status)
+	}
+	ret := new(shared.Snapshot)
+	err = json.NewDecoder(resp.Body).Decode(ret)
+	return ret, err
+}
+
+// SubscribeSnapshots applies the current snapshot before returning, then
+// polls the next ones every HartBeat.
+func (s *SnapshotSubscriber) SubscribeSnapshots(update shared.SnapshotHandlerFunc) error {
+	snapshot, err := s.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	if err := update(snapshot); err != nil {
+		return err
+	}
+	go func() {
+		c := time.Tick(s.hartBeat())
+		for _ = range c {
+			s.poll(update)
+		}
+	}()
+	return nil
+}
+
+func (s *SnapshotSubscriber) poll(update shared.SnapshotHandlerFunc) {
+	snapshot, err := s.GetSnapshot()
+	if err != nil {
+		s.logger().Error("cannot retrieve snapshot", "transport", transport, "error", err)
+		s.instrumentation().SubscriberError(snapshotId, transport, err)
+		s.failing = true
+		return
+	}
+	if s.failing {
+		s.logger().Info("snapshot retrieved again", "transport", transport)
+		s.instrumentation().SubscriberReconnected(snapshotId, transport)
+		s.failing = false
+	}
+	if err := update(snapshot); err != nil {
+		s.logger().Error("cannot apply snapshot", "version", snapshot.Version, "error", err)
+	}
+}
@@ -0,0 +1,90 @@
+package httpsubscriber
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestSnapshotSubscriber(t *testing.T) {
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(&shared.Snapshot{
+			Version: 3,
+			Doormen: []*shared.DoormanUpdater{
+				{Id: "a", Timestamp: 1, Probabilities: []*big.Rat{big.NewRat(1, 1)}},
+				{Id: "b", Timestamp: 2, Probabilities: []*big.Rat{big.NewRat(1, 2), big.NewRat(1, 2)}},
+			},
+		})
+	}))
+	defer ts.Close()
+
+	i := new(recordingInstrumentation)
+	s := &SnapshotSubscriber{Url: ts.URL, Instrumentation: i}
+	var snapshots []*shared.Snapshot
+	update := func(snapshot *shared.Snapshot) error {
+		snapshots = append(snapshots, snapshot)
+		return nil
+	}
+	s.poll(update)
+	fail = false
+	s.poll(update)
+	if i.errors != 1 || i.reconnects != 1 || len(snapshots) != 1 {
+		t.Fatal("bad instrumentation", i.errors, i.reconnects, len(snapshots))
+	}
+	if snapshot := snapshots[0]; snapshot.Version != 3 || len(snapshot.Doormen) != 2 || snapshot.Doormen[1].Id != "b" {
+		t.Error("bad snapshot", snapshot)
+	}
+}
+
+func TestSubscribeSnapshots(t *testing.T) {
+	version := int64(0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt64(&version) == 0 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(&shared.Snapshot{Version: atomic.LoadInt64(&version)})
+	}))
+	defer ts.Close()
+
+	versions := make(chan int64, 10)
+	update := func(snapshot *shared.Snapshot) error {
+		versions <- snapshot.Version
+		return nil
+	}
+	s := &SnapshotSubscriber{Url: ts.URL, HartBeat: time.Millisecond}
+	if err := s.SubscribeSnapshots(update); err == nil {
+		t.Error("the error of the first snapshot should be returned")
+	}
+	atomic.StoreInt64(&version, 1)
+	if err := s.SubscribeSnapshots(update); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-versions:
+		if v != 1 {
+			t.Error("bad version", v)
+		}
+	default:
+		t.Fatal("the first snapshot should be applied before returning")
+	}
+	atomic.StoreInt64(&version, 2)
+	for v := int64(1); v != 2; {
+		select {
+		case v = <-versions:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the next snapshots should be polled")
+		}
+	}
+}
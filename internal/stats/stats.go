@@ -0,0 +1,131 @@
+// Package stats contains the statistical functions shared by the doormantest
+// and analysis packages.
+package stats
+
+import "math"
+
+// ChiSquared returns the chi-squared goodness of fit statistic of observed
+// counts against expected counts, and its degrees of freedom.  Categories
+// expected to be empty are not counted in the degrees of freedom; observing
+// any of them makes the statistic infinite.
+func ChiSquared(observed []int, expected []float64) (float64, int) {
+	var stat float64
+	df := -1
+	for i, e := range expected {
+		o := float64(observed[i])
+		if e == 0 {
+			if o != 0 {
+				stat = math.Inf(1)
+			}
+			continue
+		}
+		df++
+		stat += (o - e) * (o - e) / e
+	}
+	if df < 0 {
+		df = 0
+	}
+	return stat, df
+}
+
+// ChiSquaredPValue returns the probability that a chi-squared variable with
+// df degrees of freedom is at least x.
+func ChiSquaredPValue(x float64, df int) float64 {
+	if df <= 0 || math.IsInf(x, 1) {
+		if x > 0 {
+			return 0
+		}
+		return 1
+	}
+	if x <= 0 {
+		return 1
+	}
+	return upperIncompleteGamma(float64(df)/2, x/2)
+}
+
+// upperIncompleteGamma returns the regularized upper incomplete gamma
+// function Q(a, x), using its series when x < a + 1 and its continued fraction
+// otherwise.
+func upperIncompleteGamma(a, x float64) float64 {
+	const (
+		maxIterations = 1000
+		epsilon       = 1e-15
+		tiny          = 1e-300
+	)
+	lgamma, _ := math.Lgamma(a)
+	prefix := math.Exp(-x + a*math.Log(x) - lgamma)
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n < maxIterations; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*epsilon {
+				break
+			}
+		}
+		return 1 - sum*prefix
+	}
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < maxIterations; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h * prefix
+}
+
+// NormalCDF returns the probability that a standard normal variable is at
+// most x.
+func NormalCDF(x float64) float64 {
+	return math.Erfc(-x/math.Sqrt2) / 2
+}
+
+// NormalQuantile returns x such that NormalCDF(x) = p, for p in (0, 1).  It
+// uses the rational approximation of Peter Acklam refined by one step of
+// Halley's method.
+func NormalQuantile(p float64) float64 {
+	if p <= 0 {
+		return math.Inf(-1)
+	}
+	if p >= 1 {
+		return math.Inf(1)
+	}
+	a := [...]float64{-3.969683028665376e+01, 2.209460984245205e+02, -2.759285104469687e+02, 1.383577518672690e+02, -3.066479806614716e+01, 2.506628277459239e+00}
+	b := [...]float64{-5.447609879822406e+01, 1.615858368580409e+02, -1.556989798598866e+02, 6.680131188771972e+01, -1.328068155288572e+01}
+	c := [...]float64{-7.784894002430293e-03, -3.223964580411365e-01, -2.400758277161838e+00, -2.549732539343734e+00, 4.374664141464968e+00, 2.938163982698783e+00}
+	d := [...]float64{7.784695709041462e-03, 3.224671290700398e-01, 2.445134137142996e+00, 3.754408661907416e+00}
+	const low = 0.02425
+
+	var x float64
+	switch {
+	case p < low:
+		q := math.Sqrt(-2 * math.Log(p))
+		x = (((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) / ((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	case p > 1-low:
+		q := math.Sqrt(-2 * math.Log(1-p))
+		x = -(((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) / ((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	default:
+		q := p - 0.5
+		r := q * q
+		x = (((((a[0]*r+a[1])*r+a[2])*r+a[3])*r+a[4])*r + a[5]) * q / (((((b[0]*r+b[1])*r+b[2])*r+b[3])*r+b[4])*r + 1)
+	}
+	e := NormalCDF(x) - p
+	u := e * math.Sqrt(2*math.Pi) * math.Exp(x*x/2)
+	return x - u/(1+x*u/2)
+}
@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func assertAlmostEqual(t *testing.T, expected, received, epsilon float64) {
+	if math.Abs(expected-received) > epsilon {
+		t.Error("received", received, "but expected", expected)
+	}
+}
+
+func TestChiSquared(t *testing.T) {
+	stat, df := ChiSquared([]int{10, 20, 30}, []float64{20, 20, 20})
+	assertAlmostEqual(t, 10, stat, 1e-12)
+	if df != 2 {
+		t.Error("bad degrees of freedom", df)
+	}
+
+	if stat, df = ChiSquared([]int{0, 10}, []float64{0, 10}); stat != 0 || df != 0 {
+		t.Error("empty categories should be ignored", stat, df)
+	}
+	if stat, _ = ChiSquared([]int{1, 9}, []float64{0, 10}); !math.IsInf(stat, 1) {
+		t.Error("observing an impossible category should be infinite", stat)
+	}
+}
+
+func TestChiSquaredPValue(t *testing.T) {
+	// critical values at 5% and 0.1%
+	assertAlmostEqual(t, 0.05, ChiSquaredPValue(3.841459, 1), 1e-6)
+	assertAlmostEqual(t, 0.05, ChiSquaredPValue(5.991465, 2), 1e-6)
+	assertAlmostEqual(t, 0.05, ChiSquaredPValue(18.307038, 10), 1e-6)
+	assertAlmostEqual(t, 0.001, ChiSquaredPValue(29.588298, 10), 1e-7)
+	assertAlmostEqual(t, 0.05, ChiSquaredPValue(124.342113, 100), 1e-6)
+
+	if p := ChiSquaredPValue(0, 3); p != 1 {
+		t.Error(p)
+	}
+	if p := ChiSquaredPValue(math.Inf(1), 3); p != 0 {
+		t.Error(p)
+	}
+}
+
+func TestNormalCDF(t *testing.T) {
+	assertAlmostEqual(t, 0.5, NormalCDF(0), 1e-12)
+	assertAlmostEqual(t, 0.975, NormalCDF(1.959964), 1e-6)
+	assertAlmostEqual(t, 0.025, NormalCDF(-1.959964), 1e-6)
+}
+
+func TestNormalQuantile(t *testing.T) {
+	for _, p := range []float64{1e-9, 0.001, 0.01, 0.025, 0.1, 0.5, 0.8, 0.975, 0.999} {
+		assertAlmostEqual(t, p, NormalCDF(NormalQuantile(p)), p*1e-9)
+	}
+	assertAlmostEqual(t, 1.959964, NormalQuantile(0.975), 1e-6)
+	if !math.IsInf(NormalQuantile(0), -1) || !math.IsInf(NormalQuantile(1), 1) {
+		t.Error("bad bounds")
+	}
+}
@@ -0,0 +1,211 @@
+// Package kafkasubscriber reads the doorman updaters from a compacted kafka
+// topic keyed by doorman id.
+package kafkasubscriber
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/segmentio/kafka-go"
+)
+
+const transport = "kafka"
+
+// Record is a record of the topic, its key being a doorman id.  A nil value is
+// the tombstone of a deleted doorman.
+type Record struct {
+	Key           []byte
+	Value         []byte
+	Offset        int64
+	HighWaterMark int64 // the offset following the last record of the partition when read
+}
+
+// Reader reads the records of a partition from its first offset.
+type Reader interface {
+	ReadRecord(ctx context.Context) (*Record, error)
+	Close() error
+}
+
+type kafkaReader struct {
+	*kafka.Reader
+}
+
+func (r kafkaReader) ReadRecord(ctx context.Context) (*Record, error) {
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{Key: m.Key, Value: m.Value, Offset: m.Offset, HighWaterMark: m.HighWaterMark}, nil
+}
+
+// KafkaSubscriber replays a partition of a compacted topic from its start,
+// applying only the latest updater of every doorman once caught up, so stale
+// resets are not replayed.  It then follows the new records.
+type KafkaSubscriber struct {
+	Brokers         []string
+	Topic           string
+	Partition       int                    // optional, a compacted topic of updaters usually has a single partition
+	Codec           codec.Codec            // optional, JSON by default
+	Backoff         time.Duration          // optional, the delay before reading again after an error, a second by default
+	Instrumentation shared.Instrumentation // optional
+	Logger          shared.Logger          // optional
+	Resync          shared.ResyncFunc      // optional, called on a gap in the sequence numbers of a doorman
+	newReader       func() Reader          // the kafka reader when nil
+	mu              sync.Mutex
+	cancels         []context.CancelFunc
+}
+
+func (s *KafkaSubscriber) Transport() string {
+	return transport
+}
+
+func (s *KafkaSubscriber) instrumentation() shared.Instrumentation {
+	if s.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+func (s *KafkaSubscriber) logger() shared.Logger {
+	if s.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return s.Logger
+}
+
+func (s *KafkaSubscriber) codec() codec.Codec {
+	if s.Codec == nil {
+		return codec.JSON
+	}
+	return s.Codec
+}
+
+func (s *KafkaSubscriber) backoff() time.Duration {
+	if s.Backoff == 0 {
+		return time.Second
+	}
+	return s.Backoff
+}
+
+func (s *KafkaSubscriber) reader() Reader {
+	if s.newReader != nil {
+		return s.newReader()
+	}
+	return kafkaReader{kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   s.Brokers,
+		Topic:     s.Topic,
+		Partition: s.Partition,
+	})}
+}
+
+func (s *KafkaSubscriber) Subscribe(doormanId string, update shared.UpdateHandlerFunc) error {
+	return s.SubscribeMany([]string{doormanId}, update)
+}
+
+// SubscribeMany hands the records of every doorman of doormanIds to update
+// from a single reader.  The topic is replayed in the background.  The
+// sequence numbers of every doorman are checked apart.
+func (s *KafkaSubscriber) SubscribeMany(doormanIds []string, update shared.UpdateHandlerFunc) error {
+	doormen := make(map[string]shared.UpdateHandlerFunc)
+	for _, id := range doormanIds {
+		doormen[id] = (&shared.GapDetector{Resync: s.Resync, Logger: s.Logger}).Wrap(update)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancels = append(s.cancels, cancel)
+	s.mu.Unlock()
+	go s.run(ctx, s.reader(), doormen)
+	return nil
+}
+
+// Close ends every subscription.
+func (s *KafkaSubscriber) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+	s.cancels = nil
+}
+
+func (s *KafkaSubscriber) run(ctx context.Context, r Reader, doormen map[string]shared.UpdateHandlerFunc) {
+	defer r.Close()
+	doormanId := strings.Join(sortedKeys(doormen), ",")
+	latest := make(map[string]*Record) // nil once the topic is replayed
+	failing := false
+	for {
+		record, err := r.ReadRecord(ctx)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			s.logger().Error("cannot read record", "doorman_id", doormanId, "transport", transport, "error", err)
+			s.instrumentation().SubscriberError(doormanId, transport, err)
+			failing = true
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.backoff()):
+			}
+			continue
+		}
+		if failing {
+			s.logger().Info("reading again", "doorman_id", doormanId, "transport", transport)
+			s.instrumentation().SubscriberReconnected(doormanId, transport)
+			failing = false
+		}
+		if update, ok := doormen[string(record.Key)]; ok {
+			if latest != nil {
+				latest[string(record.Key)] = record
+			} else {
+				s.apply(record, update)
+			}
+		}
+		if latest != nil && record.Offset+1 >= record.HighWaterMark {
+			s.replay(latest, doormen)
+			latest = nil
+		}
+	}
+}
+
+// replay applies the latest records of the doormen in the order of the log.
+func (s *KafkaSubscriber) replay(latest map[string]*Record, doormen map[string]shared.UpdateHandlerFunc) {
+	records := make([]*Record, 0, len(latest))
+	for _, record := range latest {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Offset < records[j].Offset
+	})
+	for _, record := range records {
+		s.apply(record, doormen[string(record.Key)])
+	}
+}
+
+func (s *KafkaSubscriber) apply(record *Record, update shared.UpdateHandlerFunc) {
+	if record.Value == nil {
+		s.logger().Info("doorman deleted from the topic", "doorman_id", string(record.Key), "transport", transport, "offset", record.Offset)
+		return
+	}
+	wu := &shared.DoormanUpdater{}
+	err := s.codec().Unmarshal(record.Value, wu)
+	if err == nil {
+		err = update(wu)
+	}
+	if err != nil {
+		s.logger().Error("cannot update doorman with received data", "doorman_id", string(record.Key), "transport", transport, "offset", record.Offset, "error", err)
+	}
+}
+
+func sortedKeys(m map[string]shared.UpdateHandlerFunc) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
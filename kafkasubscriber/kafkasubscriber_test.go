@@ -0,0 +1,215 @@
+package kafkasubscriber
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// memoryLog is a partition in memory, failing the reads listed in failures.
+type memoryLog struct {
+	mu       sync.Mutex
+	records  [][2][]byte
+	appended chan struct{}
+	failures map[int]bool
+	reads    int
+}
+
+func newMemoryLog() *memoryLog {
+	return &memoryLog{appended: make(chan struct{}), failures: make(map[int]bool)}
+}
+
+func (l *memoryLog) append(t *testing.T, key string, wu *shared.DoormanUpdater) {
+	var value []byte
+	if wu != nil {
+		var err error
+		if value, err = codec.JSON.Marshal(wu); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.WriteRecord(context.Background(), []byte(key), value)
+}
+
+func (l *memoryLog) WriteRecord(ctx context.Context, key, value []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, [2][]byte{key, value})
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+func (l *memoryLog) Close() error {
+	return nil
+}
+
+func (l *memoryLog) reader() Reader {
+	return &memoryReader{log: l}
+}
+
+type memoryReader struct {
+	log    *memoryLog
+	offset int
+}
+
+func (r *memoryReader) ReadRecord(ctx context.Context) (*Record, error) {
+	for {
+		r.log.mu.Lock()
+		r.log.reads++
+		if r.log.failures[r.log.reads] {
+			r.log.mu.Unlock()
+			return nil, errors.New("broker unavailable")
+		}
+		if r.offset < len(r.log.records) {
+			record := &Record{Key: r.log.records[r.offset][0], Value: r.log.records[r.offset][1], Offset: int64(r.offset), HighWaterMark: int64(len(r.log.records))}
+			r.offset++
+			r.log.mu.Unlock()
+			return record, nil
+		}
+		appended := r.log.appended
+		r.log.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-appended:
+		}
+	}
+}
+
+func (r *memoryReader) Close() error {
+	return nil
+}
+
+// subscribe returns the channel of the timestamps of the updates.
+func subscribe(t *testing.T, s *KafkaSubscriber, doormanIds ...string) <-chan int64 {
+	timestamps := make(chan int64, 10)
+	if err := s.SubscribeMany(doormanIds, func(wu *shared.DoormanUpdater) error {
+		timestamps <- wu.Timestamp
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return timestamps
+}
+
+func expectTimestamps(t *testing.T, timestamps <-chan int64, expected ...int64) {
+	for _, e := range expected {
+		select {
+		case timestamp := <-timestamps:
+			if timestamp != e {
+				t.Error("received timestamp", timestamp, "but expected", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for timestamp", e)
+		}
+	}
+	select {
+	case timestamp := <-timestamps:
+		t.Error("unexpected timestamp", timestamp)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestReplayLatest(t *testing.T) {
+	l := newMemoryLog()
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 1})
+	l.append(t, "b", &shared.DoormanUpdater{Id: "b", Timestamp: 2})
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 3, Reset: true})
+	l.append(t, "c", &shared.DoormanUpdater{Id: "c", Timestamp: 4})
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 5})
+	l.append(t, "d", nil)
+	timestamps := subscribe(t, &KafkaSubscriber{newReader: l.reader}, "a", "b", "d")
+	expectTimestamps(t, timestamps, 2, 5)
+
+	l.append(t, "c", &shared.DoormanUpdater{Id: "c", Timestamp: 6})
+	l.append(t, "b", &shared.DoormanUpdater{Id: "b", Timestamp: 7})
+	expectTimestamps(t, timestamps, 7)
+}
+
+func TestReplayEmptyTopic(t *testing.T) {
+	l := newMemoryLog()
+	timestamps := subscribe(t, &KafkaSubscriber{newReader: l.reader}, "a")
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 1})
+	expectTimestamps(t, timestamps, 1)
+}
+
+func TestReplayTombstone(t *testing.T) {
+	l := newMemoryLog()
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 1})
+	l.append(t, "a", nil)
+	l.append(t, "b", &shared.DoormanUpdater{Id: "b", Timestamp: 2})
+	timestamps := subscribe(t, &KafkaSubscriber{newReader: l.reader}, "a", "b")
+	expectTimestamps(t, timestamps, 2)
+}
+
+type recordingInstrumentation struct {
+	shared.NopInstrumentation
+	mu         sync.Mutex
+	errors     int
+	reconnects int
+}
+
+func (i *recordingInstrumentation) SubscriberError(doormanId, transport string, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.errors++
+}
+
+func (i *recordingInstrumentation) SubscriberReconnected(doormanId, transport string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.reconnects++
+}
+
+func TestReadErrors(t *testing.T) {
+	l := newMemoryLog()
+	l.failures[1] = true
+	l.failures[2] = true
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 1})
+	i := new(recordingInstrumentation)
+	timestamps := subscribe(t, &KafkaSubscriber{newReader: l.reader, Backoff: time.Millisecond, Instrumentation: i}, "a")
+	expectTimestamps(t, timestamps, 1)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.errors != 2 || i.reconnects != 1 {
+		t.Error("bad instrumentation", i.errors, i.reconnects)
+	}
+}
+
+func TestGapDetection(t *testing.T) {
+	l := newMemoryLog()
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 1, Sequence: 1})
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 2, Sequence: 2})
+	l.append(t, "b", &shared.DoormanUpdater{Id: "b", Timestamp: 3, Sequence: 1})
+	resyncs := make(chan string, 10)
+	s := &KafkaSubscriber{newReader: l.reader, Resync: func(doormanId string) (*shared.DoormanUpdater, error) {
+		resyncs <- doormanId
+		return &shared.DoormanUpdater{Id: doormanId, Timestamp: 100}, nil
+	}}
+	// only the latest updaters are replayed, which is no gap
+	timestamps := subscribe(t, s, "a", "b")
+	expectTimestamps(t, timestamps, 2, 3)
+
+	// 3 of a is missing, 2 of b follows 1 and 4 of a is delivered again
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 4, Sequence: 4})
+	l.append(t, "b", &shared.DoormanUpdater{Id: "b", Timestamp: 5, Sequence: 2})
+	l.append(t, "a", &shared.DoormanUpdater{Id: "a", Timestamp: 6, Sequence: 4})
+	expectTimestamps(t, timestamps, 100, 4, 5)
+	select {
+	case doormanId := <-resyncs:
+		if doormanId != "a" {
+			t.Error("resynchronized the wrong doorman", doormanId)
+		}
+	default:
+		t.Error("not resynchronized")
+	}
+	if len(resyncs) != 0 {
+		t.Error("resynchronized too often")
+	}
+}
@@ -0,0 +1,98 @@
+package kafkasubscriber
+
+import (
+	"context"
+	"sync"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/segmentio/kafka-go"
+)
+
+// Writer appends records to a topic.
+type Writer interface {
+	WriteRecord(ctx context.Context, key, value []byte) error
+	Close() error
+}
+
+type kafkaWriter struct {
+	*kafka.Writer
+}
+
+func (w kafkaWriter) WriteRecord(ctx context.Context, key, value []byte) error {
+	return w.WriteMessages(ctx, kafka.Message{Key: key, Value: value})
+}
+
+// partition balances every record to the same partition.
+type partition int
+
+func (p partition) Balance(msg kafka.Message, partitions ...int) int {
+	return int(p)
+}
+
+// KafkaPublisher appends the updater of a doorman to the topic, keyed by the
+// doorman id so the compaction keeps its latest updater.  Every record goes to
+// Partition, the partition read by the KafkaSubscriber.
+type KafkaPublisher struct {
+	Brokers   []string
+	Topic     string
+	Partition int           // optional, the Partition of the subscribers
+	Codec     codec.Codec   // optional, JSON by default
+	newWriter func() Writer // the kafka writer when nil
+	mu        sync.Mutex
+	writer    Writer
+	seq       shared.Sequencer
+}
+
+func (p *KafkaPublisher) codec() codec.Codec {
+	if p.Codec == nil {
+		return codec.JSON
+	}
+	return p.Codec
+}
+
+func (p *KafkaPublisher) newKafkaWriter() Writer {
+	if p.newWriter != nil {
+		return p.newWriter()
+	}
+	return kafkaWriter{&kafka.Writer{
+		Addr:         kafka.TCP(p.Brokers...),
+		Topic:        p.Topic,
+		Balancer:     partition(p.Partition),
+		RequiredAcks: kafka.RequireAll,
+	}}
+}
+
+func (p *KafkaPublisher) getWriter() Writer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writer == nil {
+		p.writer = p.newKafkaWriter()
+	}
+	return p.writer
+}
+
+// Publish appends wu numbered with the next sequence number of its doorman and
+// returns once every in-sync replica stored the record.
+func (p *KafkaPublisher) Publish(wu *shared.DoormanUpdater) error {
+	return p.seq.Publish(wu, p.publish)
+}
+
+func (p *KafkaPublisher) publish(wu *shared.DoormanUpdater) error {
+	data, err := p.codec().Marshal(wu)
+	if err != nil {
+		return err
+	}
+	return p.getWriter().WriteRecord(context.Background(), []byte(wu.Id), data)
+}
+
+func (p *KafkaPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writer == nil {
+		return nil
+	}
+	err := p.writer.Close()
+	p.writer = nil
+	return err
+}
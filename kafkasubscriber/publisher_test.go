@@ -0,0 +1,44 @@
+package kafkasubscriber
+
+import (
+	"testing"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaPublisher(t *testing.T) {
+	l := newMemoryLog()
+	p := &KafkaPublisher{Codec: codec.MsgPack, newWriter: func() Writer { return l }}
+	defer p.Close()
+	for _, wu := range []*shared.DoormanUpdater{{Id: "a", Timestamp: 1}, {Id: "b", Timestamp: 2}, {Id: "a", Timestamp: 3}} {
+		if err := p.Publish(wu); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.records) != 3 || string(l.records[1][0]) != "b" {
+		t.Fatal("bad records", l.records)
+	}
+	for i, expected := range []uint64{1, 1, 2} {
+		wu := new(shared.DoormanUpdater)
+		if err := codec.MsgPack.Unmarshal(l.records[i][1], wu); err != nil {
+			t.Fatal(err)
+		}
+		if wu.Sequence != expected {
+			t.Error("the updaters of a doorman should be numbered", i, wu.Sequence)
+		}
+	}
+	timestamps := subscribe(t, &KafkaSubscriber{Codec: codec.MsgPack, newReader: l.reader}, "a")
+	expectTimestamps(t, timestamps, 3)
+}
+
+func TestKafkaPublisherPartition(t *testing.T) {
+	w := (&KafkaPublisher{Partition: 2}).newKafkaWriter().(kafkaWriter)
+	defer w.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		if p := w.Balancer.Balance(kafka.Message{Key: []byte(key)}, 0, 1, 2, 3); p != 2 {
+			t.Error("the record of", key, "went to partition", p)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package doorman
+
+import "encoding/binary"
+
+// The canonical encodings of typed keys.  Every doorman client, whatever its
+// language, must encode keys the same way to bucket them identically.  The
+// golden vectors in testdata/keys.json can be reused to check an
+// implementation.
+
+// EncodeInt encodes a signed integer as its 8 bytes two's complement, little
+// endian.
+func EncodeInt(data int64) []byte {
+	return EncodeUint64(uint64(data))
+}
+
+// EncodeUint64 encodes an unsigned integer as its 8 bytes, little endian.
+func EncodeUint64(data uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, data)
+	return b
+}
+
+// EncodeUUID encodes a UUID as its 16 bytes in RFC 4122 order, that is the
+// order of its hexadecimal representation.
+func EncodeUUID(data [16]byte) []byte {
+	return append([]byte(nil), data[:]...)
+}
+
+// EncodeKey encodes the ordered fields of a composite key.  Each field is
+// prefixed by its length as a 4 bytes little endian unsigned integer so that
+// ("ab", "c") and ("a", "bc") are different keys.
+func EncodeKey(fields ...[]byte) []byte {
+	size := 0
+	for _, field := range fields {
+		size += 4 + len(field)
+	}
+	b := make([]byte, 0, size)
+	for _, field := range fields {
+		var l [4]byte
+		binary.LittleEndian.PutUint32(l[:], uint32(len(field)))
+		b = append(append(b, l[:]...), field...)
+	}
+	return b
+}
@@ -0,0 +1,188 @@
+package doorman
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden files in testdata")
+
+const keysGoldenFile = "testdata/keys.json"
+
+// keyField is a typed field of a key as written in the golden files.  Values
+// are strings so that 64 bits integers survive JSON parsers using doubles.
+type keyField struct {
+	Type  string `json:"type"` // int, uint64, uuid, string or key
+	Value string `json:"value,omitempty"`
+	// the fields of a composite key
+	Fields []*keyField `json:"fields,omitempty"`
+}
+
+func (f *keyField) encode() ([]byte, error) {
+	switch f.Type {
+	case "int":
+		i, err := strconv.ParseInt(f.Value, 10, 64)
+		return EncodeInt(i), err
+	case "uint64":
+		i, err := strconv.ParseUint(f.Value, 10, 64)
+		return EncodeUint64(i), err
+	case "uuid":
+		var u [16]byte
+		b, err := hex.DecodeString(strings.Replace(f.Value, "-", "", -1))
+		if err == nil && len(b) != len(u) {
+			err = fmt.Errorf("bad uuid %v", f.Value)
+		}
+		copy(u[:], b)
+		return EncodeUUID(u), err
+	case "string":
+		return []byte(f.Value), nil
+	case "key":
+		fields := make([][]byte, len(f.Fields))
+		for i, field := range f.Fields {
+			var err error
+			if fields[i], err = field.encode(); err != nil {
+				return nil, err
+			}
+		}
+		return EncodeKey(fields...), nil
+	}
+	return nil, fmt.Errorf("unknown key type %v", f.Type)
+}
+
+type keyVector struct {
+	Key      *keyField `json:"key"`
+	Encoding string    `json:"encoding"` // hexadecimal
+	Hash     string    `json:"hash"`     // hexadecimal
+	Case     uint      `json:"case"`
+}
+
+type keysGolden struct {
+	Id            string       `json:"id"`
+	Probabilities []string     `json:"probabilities"`
+	Vectors       []*keyVector `json:"vectors"`
+}
+
+var keysGoldenKeys = []*keyField{
+	{Type: "int", Value: "0"},
+	{Type: "int", Value: "2"},
+	{Type: "int", Value: "13"},
+	{Type: "int", Value: "195"},
+	{Type: "int", Value: "-1"},
+	{Type: "int", Value: "-9223372036854775808"},
+	{Type: "int", Value: "9223372036854775807"},
+	{Type: "uint64", Value: "0"},
+	{Type: "uint64", Value: "195"},
+	{Type: "uint64", Value: "18446744073709551615"},
+	{Type: "uuid", Value: "00000000-0000-0000-0000-000000000000"},
+	{Type: "uuid", Value: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+	{Type: "uuid", Value: "f47ac10b-58cc-4372-a567-0e02b2c3d479"},
+	{Type: "key", Fields: []*keyField{}},
+	{Type: "key", Fields: []*keyField{{Type: "string", Value: ""}}},
+	{Type: "key", Fields: []*keyField{{Type: "string", Value: "ab"}, {Type: "string", Value: "c"}}},
+	{Type: "key", Fields: []*keyField{{Type: "string", Value: "a"}, {Type: "string", Value: "bc"}}},
+	{Type: "key", Fields: []*keyField{{Type: "int", Value: "42"}, {Type: "string", Value: "iphone"}}},
+	{Type: "key", Fields: []*keyField{
+		{Type: "uuid", Value: "f47ac10b-58cc-4372-a567-0e02b2c3d479"},
+		{Type: "uint64", Value: "7"},
+		{Type: "string", Value: "Հայաստան"},
+	}},
+}
+
+func generateKeysGolden() (*keysGolden, error) {
+	w := newDoorman(getProbs("1/4", "1/2", "1/4"))
+	ret := &keysGolden{Id: w.Id, Probabilities: []string{"1/4", "1/2", "1/4"}}
+	for _, key := range keysGoldenKeys {
+		b, err := key.encode()
+		if err != nil {
+			return nil, err
+		}
+		ret.Vectors = append(ret.Vectors, &keyVector{
+			Key:      key,
+			Encoding: hex.EncodeToString(b),
+			Hash:     fmt.Sprintf("%016x", w.Hash(b)),
+			Case:     w.GetCaseFromData(b),
+		})
+	}
+	return ret, nil
+}
+
+func TestKeysGolden(t *testing.T) {
+	if *update {
+		golden, err := generateKeysGolden()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.MarshalIndent(golden, "", "  ")
+		if err := ioutil.WriteFile(keysGoldenFile, append(b, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(keysGoldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := new(keysGolden)
+	if err := json.Unmarshal(b, golden); err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(golden.Id, getProbs(golden.Probabilities...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range golden.Vectors {
+		b, err := v.Key.encode()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if e := hex.EncodeToString(b); e != v.Encoding {
+			t.Error("bad encoding of", v.Key, e)
+		}
+		if h := fmt.Sprintf("%016x", w.Hash(b)); h != v.Hash {
+			t.Error("bad hash of", v.Key, h)
+		}
+		if c := w.GetCaseFromData(b); c != v.Case {
+			t.Error("bad case of", v.Key, c)
+		}
+	}
+}
+
+func TestGetCaseFromInt(t *testing.T) {
+	w := newDoorman(getProbs("1/4", "1/2", "1/4"))
+	for _, i := range []int64{0, 2, 13, 195, -1} {
+		if w.GetCaseFromInt(i) != w.GetCaseFromData(EncodeInt(i)) {
+			t.Error("incorrect results for: ", i)
+		}
+		if w.GetCaseFromInt(i) != w.GetCaseFromUint64(uint64(i)) {
+			t.Error("int and uint64 of the same bits should have the same case", i)
+		}
+	}
+}
+
+func TestGetCaseFromUUID(t *testing.T) {
+	w := newDoorman(getProbs("1/4", "1/2", "1/4"))
+	u := [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	if w.GetCaseFromUUID(u) != w.GetCaseFromData(u[:]) {
+		t.Error("uuid should be hashed as its 16 bytes")
+	}
+}
+
+func TestEncodeKey(t *testing.T) {
+	if !bytes.Equal(EncodeKey([]byte("ab"), []byte("c")), []byte("\x02\x00\x00\x00ab\x01\x00\x00\x00c")) {
+		t.Error("bad key encoding")
+	}
+	if bytes.Equal(EncodeKey([]byte("ab"), []byte("c")), EncodeKey([]byte("a"), []byte("bc"))) {
+		t.Error("field boundaries should be part of the key")
+	}
+	if len(EncodeKey()) != 0 {
+		t.Error("empty key should be empty")
+	}
+}
@@ -0,0 +1,97 @@
+package kvsubscriber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Consul reads the keys of Consul with its blocking queries.
+type Consul struct {
+	Address string        // the url of the agent, like http://127.0.0.1:8500
+	Token   string        // optional, the acl token
+	Wait    time.Duration // optional, the longest wait of a blocking query, five minutes by default
+	Client  *http.Client  // optional, http.DefaultClient by default
+}
+
+func (c *Consul) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+	return c.Client
+}
+
+func (c *Consul) request(ctx context.Context, method, key string, query url.Values, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.Address+"/v1/kv/"+key+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	return c.client().Do(req)
+}
+
+func (c *Consul) Get(ctx context.Context, key string, index int64) (*Value, int64, error) {
+	query := url.Values{}
+	if index > 0 {
+		wait := c.Wait
+		if wait == 0 {
+			wait = 5 * time.Minute
+		}
+		query.Set("index", strconv.FormatInt(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(wait.Seconds())))
+	}
+	resp, err := c.request(ctx, "GET", key, query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	next, err := strconv.ParseInt(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, errors.New("bad consul index, " + err.Error())
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, next, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New("bad http status when GETting key, " + resp.Status)
+	}
+	var pairs []struct {
+		ModifyIndex int64
+		Value       []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, 0, err
+	}
+	if len(pairs) == 0 {
+		return nil, next, nil
+	}
+	return &Value{Data: pairs[0].Value, Revision: pairs[0].ModifyIndex}, next, nil
+}
+
+func (c *Consul) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := c.request(ctx, "PUT", key, url.Values{}, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("bad http status when PUTting key, " + resp.Status)
+	}
+	// consul answers false when the key is not written
+	var written bool
+	if err := json.NewDecoder(resp.Body).Decode(&written); err != nil {
+		return err
+	}
+	if !written {
+		return errors.New("key not written")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package kvsubscriber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Etcd reads the keys of etcd with the JSON gateway of its v3 api.
+type Etcd struct {
+	Endpoint string       // the url of a member, like http://127.0.0.1:2379
+	Client   *http.Client // optional, http.DefaultClient by default
+}
+
+type etcdKeyValue struct {
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+func (e *Etcd) client() *http.Client {
+	if e.Client == nil {
+		return http.DefaultClient
+	}
+	return e.Client
+}
+
+func (e *Etcd) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.Endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New("bad http status when POSTing " + path + ", " + resp.Status)
+	}
+	return resp, nil
+}
+
+// Get ranges over key when index is zero and watches it from the next
+// revision otherwise.  The index is the revision of etcd.
+func (e *Etcd) Get(ctx context.Context, key string, index int64) (*Value, int64, error) {
+	if index == 0 {
+		return e.get(ctx, key)
+	}
+	return e.watch(ctx, key, index)
+}
+
+func (e *Etcd) get(ctx context.Context, key string) (*Value, int64, error) {
+	resp, err := e.post(ctx, "/v3/kv/range", map[string]interface{}{"key": []byte(key)})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var ret struct {
+		Header etcdHeader     `json:"header"`
+		Kvs    []etcdKeyValue `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, 0, err
+	}
+	if len(ret.Kvs) == 0 {
+		return nil, ret.Header.Revision, nil
+	}
+	return &Value{Data: ret.Kvs[0].Value, Revision: ret.Kvs[0].ModRevision}, ret.Header.Revision, nil
+}
+
+func (e *Etcd) watch(ctx context.Context, key string, index int64) (*Value, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // closes the watch
+	request := map[string]interface{}{"create_request": map[string]interface{}{"key": []byte(key), "start_revision": index + 1}}
+	resp, err := e.post(ctx, "/v3/watch", request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	d := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Result struct {
+				Canceled bool `json:"canceled"`
+				Events   []struct {
+					Type string       `json:"type"`
+					Kv   etcdKeyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+		}
+		if err := d.Decode(&message); err != nil {
+			return nil, 0, err
+		}
+		if message.Result.Canceled {
+			// typically because index is compacted, the current value is
+			// the next one anyway
+			return e.get(ctx, key)
+		}
+		if len(message.Result.Events) == 0 {
+			continue
+		}
+		event := message.Result.Events[0]
+		if event.Type == "DELETE" {
+			return nil, event.Kv.ModRevision, nil
+		}
+		return &Value{Data: event.Kv.Value, Revision: event.Kv.ModRevision}, event.Kv.ModRevision, nil
+	}
+}
+
+// Put writes key.  The gateway may report the failure of the put in the body
+// of a 200 response.
+func (e *Etcd) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := e.post(ctx, "/v3/kv/put", map[string]interface{}{"key": []byte(key), "value": data})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var ret struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return err
+	}
+	if ret.Error != "" || ret.Code != 0 {
+		return errors.New("cannot put key: " + ret.Error + ret.Message)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package kvsubscriber reads the doorman updaters from a key per doorman of a
+// key-value store, such as etcd or Consul, and watches their changes.
+package kvsubscriber
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+const transport = "kv"
+
+const defaultKeyPrefix = "doorman/"
+
+// Value is the value of a key and its modification revision.
+type Value struct {
+	Data     []byte
+	Revision int64
+}
+
+// Store reads the keys of a key-value store.
+type Store interface {
+	// Get returns the value of key, nil when absent, and the index of the
+	// store to pass to the next call.  When index is not zero, Get waits for
+	// a change of key after index, or until the store gives up waiting.
+	Get(ctx context.Context, key string, index int64) (*Value, int64, error)
+}
+
+// KVSubscriber reads the JSON updater of a doorman from the key KeyPrefix+id
+// and watches it.  The modification revision of the key is the timestamp of
+// the update, the way nsqsubscriber uses the timestamp of the messages.
+type KVSubscriber struct {
+	Store           Store
+	KeyPrefix       string                 // optional, "doorman/" by default
+	Backoff         time.Duration          // optional, the delay before watching again after an error, a second by default
+	Instrumentation shared.Instrumentation // optional
+	Logger          shared.Logger          // optional
+	mu              sync.Mutex
+	cancels         []context.CancelFunc
+}
+
+func (s *KVSubscriber) Transport() string {
+	return transport
+}
+
+func (s *KVSubscriber) instrumentation() shared.Instrumentation {
+	if s.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+func (s *KVSubscriber) logger() shared.Logger {
+	if s.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return s.Logger
+}
+
+func (s *KVSubscriber) backoff() time.Duration {
+	if s.Backoff == 0 {
+		return time.Second
+	}
+	return s.Backoff
+}
+
+func key(prefix, doormanId string) string {
+	if prefix == "" {
+		return defaultKeyPrefix + doormanId
+	}
+	return prefix + doormanId
+}
+
+func (s *KVSubscriber) key(doormanId string) string {
+	return key(s.KeyPrefix, doormanId)
+}
+
+func toUpdater(value *Value) (*shared.DoormanUpdater, error) {
+	wu := &shared.DoormanUpdater{}
+	if err := json.Unmarshal(value.Data, wu); err != nil {
+		return nil, err
+	}
+	wu.Timestamp = value.Revision
+	return wu, nil
+}
+
+func (s *KVSubscriber) Subscribe(doormanId string, update shared.UpdateHandlerFunc) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	value, index, err := s.Store.Get(ctx, s.key(doormanId), 0)
+	if err == nil && value != nil {
+		var wu *shared.DoormanUpdater
+		if wu, err = toUpdater(value); err == nil {
+			err = update(wu)
+		}
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+	s.mu.Lock()
+	s.cancels = append(s.cancels, cancel)
+	s.mu.Unlock()
+	go s.watch(ctx, doormanId, index, value, update)
+	return nil
+}
+
+// Close ends every subscription.
+func (s *KVSubscriber) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+	s.cancels = nil
+}
+
+// watch applies the changes of the key after index, last being its current
+// value.
+func (s *KVSubscriber) watch(ctx context.Context, doormanId string, index int64, last *Value, update shared.UpdateHandlerFunc) {
+	failing := false
+	for {
+		value, next, err := s.Store.Get(ctx, s.key(doormanId), index)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			s.logger().Error("cannot watch key", "doorman_id", doormanId, "transport", transport, "error", err)
+			s.instrumentation().SubscriberError(doormanId, transport, err)
+			failing = true
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.backoff()):
+			}
+			continue
+		}
+		if failing {
+			s.logger().Info("watching again", "doorman_id", doormanId, "transport", transport)
+			s.instrumentation().SubscriberReconnected(doormanId, transport)
+			failing = false
+		}
+		// an index going backward means the store was restored, start over
+		if next < index {
+			next = 0
+		}
+		index = next
+		if value == nil {
+			if last != nil {
+				s.logger().Info("doorman key deleted", "doorman_id", doormanId, "transport", transport, "key", s.key(doormanId))
+			}
+			last = nil
+			continue
+		}
+		if last != nil && value.Revision == last.Revision {
+			continue
+		}
+		last = value
+		wu, err := toUpdater(value)
+		if err == nil {
+			err = update(wu)
+		}
+		if err != nil {
+			s.logger().Error("cannot update doorman with received data", "doorman_id", doormanId, "transport", transport, "error", err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package kvsubscriber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// memoryKV is a key-value store in memory.  Its revision increases with every
+// change, like the index of Consul and the revision of etcd.
+type memoryKV struct {
+	mu       sync.Mutex
+	revision int64
+	values   map[string]*Value
+	changed  chan struct{}
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{values: make(map[string]*Value), changed: make(chan struct{})}
+}
+
+func (kv *memoryKV) set(t *testing.T, key string, wu *shared.DoormanUpdater) {
+	if wu == nil {
+		kv.put(key, nil)
+	} else if data, err := json.Marshal(wu); err != nil {
+		t.Fatal(err)
+	} else {
+		kv.put(key, data)
+	}
+}
+
+// put sets key to data, or deletes it when data is nil.
+func (kv *memoryKV) put(key string, data []byte) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.revision++
+	if data == nil {
+		delete(kv.values, key)
+	} else {
+		kv.values[key] = &Value{Data: data, Revision: kv.revision}
+	}
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+// wait returns the value of key once the store is past index.
+func (kv *memoryKV) wait(done <-chan struct{}, key string, index int64) (*Value, int64, bool) {
+	for {
+		kv.mu.Lock()
+		if kv.revision > index {
+			defer kv.mu.Unlock()
+			return kv.values[key], kv.revision, true
+		}
+		changed := kv.changed
+		kv.mu.Unlock()
+		select {
+		case <-done:
+			return nil, 0, false
+		case <-changed:
+		}
+	}
+}
+
+// consulServer serves the kv of Consul with its blocking queries.
+func consulServer(kv *memoryKV) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			data, _ := io.ReadAll(r.Body)
+			kv.put(strings.TrimPrefix(r.URL.Path, "/v1/kv/"), data)
+			io.WriteString(w, "true")
+			return
+		}
+		index := int64(-1) // answers at once without index
+		if r.URL.Query().Get("index") != "" {
+			index, _ = strconv.ParseInt(r.URL.Query().Get("index"), 10, 64)
+		}
+		value, revision, ok := kv.wait(r.Context().Done(), strings.TrimPrefix(r.URL.Path, "/v1/kv/"), index)
+		if !ok {
+			return
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatInt(revision, 10))
+		if value == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"ModifyIndex": value.Revision, "Value": value.Data}})
+	}))
+}
+
+// etcdServer serves the put, range and watch requests of the JSON gateway of
+// etcd, every change being a put of the key.
+func etcdServer(kv *memoryKV) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key           []byte `json:"key"`
+			Value         []byte `json:"value"`
+			CreateRequest struct {
+				Key           []byte `json:"key"`
+				StartRevision int64  `json:"start_revision"`
+			} `json:"create_request"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		e := json.NewEncoder(w)
+		switch r.URL.Path {
+		case "/v3/kv/put":
+			kv.put(string(req.Key), req.Value)
+			e.Encode(map[string]interface{}{})
+		case "/v3/kv/range":
+			value, revision, _ := kv.wait(nil, string(req.Key), -1)
+			ret := map[string]interface{}{"header": map[string]string{"revision": strconv.FormatInt(revision, 10)}}
+			if value != nil {
+				ret["kvs"] = []map[string]interface{}{{"value": value.Data, "mod_revision": strconv.FormatInt(value.Revision, 10)}}
+			}
+			e.Encode(ret)
+		case "/v3/watch":
+			e.Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+			w.(http.Flusher).Flush()
+			value, revision, ok := kv.wait(r.Context().Done(), string(req.CreateRequest.Key), req.CreateRequest.StartRevision-1)
+			if !ok {
+				return
+			}
+			event := map[string]interface{}{"type": "DELETE", "kv": map[string]string{"mod_revision": strconv.FormatInt(revision, 10)}}
+			if value != nil {
+				event = map[string]interface{}{"kv": map[string]interface{}{"value": value.Data, "mod_revision": strconv.FormatInt(value.Revision, 10)}}
+			}
+			e.Encode(map[string]interface{}{"result": map[string]interface{}{"events": []interface{}{event}}})
+		}
+	}))
+}
+
+// subscribe returns the channel of the timestamps of the updates.
+func subscribe(t *testing.T, s *KVSubscriber, doormanId string) <-chan int64 {
+	timestamps := make(chan int64, 10)
+	if err := s.Subscribe(doormanId, func(wu *shared.DoormanUpdater) error {
+		timestamps <- wu.Timestamp
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return timestamps
+}
+
+func expectTimestamps(t *testing.T, timestamps <-chan int64, expected ...int64) {
+	for _, e := range expected {
+		select {
+		case timestamp := <-timestamps:
+			if timestamp != e {
+				t.Error("received timestamp", timestamp, "but expected", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for timestamp", e)
+		}
+	}
+	select {
+	case timestamp := <-timestamps:
+		t.Error("unexpected timestamp", timestamp)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWatch(t *testing.T) {
+	for name, server := range map[string]func(*memoryKV) *httptest.Server{"consul": consulServer, "etcd": etcdServer} {
+		kv := newMemoryKV()
+		kv.set(t, "doorman/foo", &shared.DoormanUpdater{Id: "foo", Timestamp: 1000})
+		kv.set(t, "doorman/bar", &shared.DoormanUpdater{Id: "bar"})
+		ts := server(kv)
+		store := Store(&Consul{Address: ts.URL})
+		if name == "etcd" {
+			store = &Etcd{Endpoint: ts.URL}
+		}
+		s := &KVSubscriber{Store: store}
+		timestamps := subscribe(t, s, "foo")
+		// the revision is the timestamp
+		expectTimestamps(t, timestamps, 1)
+
+		kv.set(t, "doorman/foo", &shared.DoormanUpdater{Id: "foo"})
+		expectTimestamps(t, timestamps, 3)
+		kv.set(t, "doorman/bar", &shared.DoormanUpdater{Id: "bar"})
+		kv.set(t, "doorman/foo", nil)
+		kv.set(t, "doorman/foo", &shared.DoormanUpdater{Id: "foo"})
+		expectTimestamps(t, timestamps, 6)
+		s.Close()
+		ts.Close()
+	}
+}
+
+// failingStore fails the watches listed in failures.
+type failingStore struct {
+	Store
+	mu       sync.Mutex
+	calls    int
+	failures map[int]bool
+}
+
+func (s *failingStore) Get(ctx context.Context, key string, index int64) (*Value, int64, error) {
+	s.mu.Lock()
+	s.calls++
+	fail := s.failures[s.calls]
+	s.mu.Unlock()
+	if fail {
+		return nil, 0, errors.New("store unavailable")
+	}
+	return s.Store.Get(ctx, key, index)
+}
+
+type recordingInstrumentation struct {
+	shared.NopInstrumentation
+	mu         sync.Mutex
+	errors     int
+	reconnects int
+}
+
+func (i *recordingInstrumentation) SubscriberError(doormanId, transport string, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.errors++
+}
+
+func (i *recordingInstrumentation) SubscriberReconnected(doormanId, transport string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.reconnects++
+}
+
+func TestWatchErrors(t *testing.T) {
+	kv := newMemoryKV()
+	ts := consulServer(kv)
+	t.Cleanup(ts.Close)
+	store := &failingStore{Store: &Consul{Address: ts.URL}, failures: map[int]bool{2: true, 3: true}}
+	i := new(recordingInstrumentation)
+	timestamps := subscribe(t, &KVSubscriber{Store: store, Backoff: time.Millisecond, Instrumentation: i}, "foo")
+	kv.set(t, "doorman/foo", &shared.DoormanUpdater{Id: "foo"})
+	expectTimestamps(t, timestamps, 1)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.errors != 2 || i.reconnects != 1 {
+		t.Error("bad instrumentation", i.errors, i.reconnects)
+	}
+
+	store = &failingStore{Store: &Consul{Address: ts.URL}, failures: map[int]bool{1: true}}
+	if err := (&KVSubscriber{Store: store}).Subscribe("foo", func(wu *shared.DoormanUpdater) error { return nil }); err == nil {
+		t.Error("expected the error of the first read")
+	}
+}
@@ -0,0 +1,46 @@
+package kvsubscriber
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// Writer writes the keys of a key-value store.  Consul and Etcd are writers.
+type Writer interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// KVPublisher writes the JSON updater of a doorman to the key KeyPrefix+id.
+// The subscribers use the modification revision of the key as timestamp, not
+// the one of the updater.
+type KVPublisher struct {
+	Store     Writer
+	KeyPrefix string        // optional, "doorman/" by default
+	Timeout   time.Duration // optional, the deadline of the writes of Publish, 10 seconds by default
+}
+
+func (p *KVPublisher) timeout() time.Duration {
+	if p.Timeout == 0 {
+		return 10 * time.Second
+	}
+	return p.Timeout
+}
+
+// Publish writes wu, giving up after Timeout.
+func (p *KVPublisher) Publish(wu *shared.DoormanUpdater) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+	return p.PublishContext(ctx, wu)
+}
+
+// PublishContext writes wu until ctx is done.
+func (p *KVPublisher) PublishContext(ctx context.Context, wu *shared.DoormanUpdater) error {
+	data, err := json.Marshal(wu)
+	if err != nil {
+		return err
+	}
+	return p.Store.Put(ctx, key(p.KeyPrefix, wu.Id), data)
+}
@@ -0,0 +1,67 @@
+package kvsubscriber
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestKVPublisher(t *testing.T) {
+	for name, server := range map[string]func(*memoryKV) *httptest.Server{"consul": consulServer, "etcd": etcdServer} {
+		kv := newMemoryKV()
+		ts := server(kv)
+		var store interface {
+			Store
+			Writer
+		} = &Consul{Address: ts.URL}
+		if name == "etcd" {
+			store = &Etcd{Endpoint: ts.URL}
+		}
+		p := &KVPublisher{Store: store, KeyPrefix: "flags/"}
+		if err := p.Publish(&shared.DoormanUpdater{Id: "foo", Timestamp: 1000}); err != nil {
+			t.Fatal(name, err)
+		}
+		s := &KVSubscriber{Store: store, KeyPrefix: "flags/"}
+		timestamps := subscribe(t, s, "foo")
+		expectTimestamps(t, timestamps, 1)
+		if err := p.Publish(&shared.DoormanUpdater{Id: "foo"}); err != nil {
+			t.Fatal(name, err)
+		}
+		expectTimestamps(t, timestamps, 2)
+		s.Close()
+		ts.Close()
+	}
+}
+
+func TestKVPublisherErrors(t *testing.T) {
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"error": "etcdserver: request is too large", "code": 3}`)
+	}))
+	defer etcd.Close()
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "false")
+	}))
+	defer consul.Close()
+	for name, store := range map[string]Writer{"etcd": &Etcd{Endpoint: etcd.URL}, "consul": &Consul{Address: consul.URL}} {
+		if err := (&KVPublisher{Store: store}).Publish(&shared.DoormanUpdater{Id: "foo"}); err == nil {
+			t.Error(name, "should report the failed write")
+		}
+	}
+
+	release := make(chan bool)
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+	p := &KVPublisher{Store: &Etcd{Endpoint: hung.URL}, Timeout: 10 * time.Millisecond}
+	if err := p.Publish(&shared.DoormanUpdater{Id: "foo"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("should received a deadline exceeded error", err)
+	}
+}
@@ -0,0 +1,147 @@
+// Package metrics collects the events of doorman clients and exports them in
+// the Prometheus text format.
+//
+// A *Metrics is both a shared.Instrumentation, to set as the Instrumentation
+// of the doormen, and an http.Handler, to serve on the scraped endpoint.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type counter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.RWMutex
+	values map[string]*uint64 // by joined label values
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{name: name, help: help, labels: labels, values: make(map[string]*uint64)}
+}
+
+func (c *counter) inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.RLock()
+	v, ok := c.values[key]
+	c.mu.RUnlock()
+	if !ok {
+		c.mu.Lock()
+		if v, ok = c.values[key]; !ok {
+			v = new(uint64)
+			c.values[key] = v
+		}
+		c.mu.Unlock()
+	}
+	atomic.AddUint64(v, 1)
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.RLock()
+	lines := make([]string, 0, len(c.values))
+	for key, v := range c.values {
+		lines = append(lines, fmt.Sprintf("%v%v %v\n", c.name, formatLabels(c.labels, strings.Split(key, "\xff")), atomic.LoadUint64(v)))
+	}
+	c.mu.RUnlock()
+	sort.Strings(lines)
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", c.name, c.help, c.name)
+	for _, line := range lines {
+		io.WriteString(w, line)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelValueEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Metrics counts the events of any number of doormen.  It is goroutine safe.
+type Metrics struct {
+	evaluations *counter
+	accepted    *counter
+	rejected    *counter
+	reconnects  *counter
+	errors      *counter
+	mu          sync.Mutex
+	lastUpdates map[string]time.Time // the time of the last accepted update by doorman id
+	now         func() time.Time
+}
+
+func New() *Metrics {
+	return &Metrics{
+		evaluations: newCounter("doorman_evaluations_total", "Number of cases evaluated.", "doorman", "case"),
+		accepted:    newCounter("doorman_updates_accepted_total", "Number of updates accepted.", "doorman"),
+		rejected:    newCounter("doorman_updates_rejected_total", "Number of updates rejected.", "doorman", "reason"),
+		reconnects:  newCounter("doorman_subscriber_reconnects_total", "Number of times a subscriber recovered from an error.", "doorman", "transport"),
+		errors:      newCounter("doorman_subscriber_errors_total", "Number of subscriber errors.", "doorman", "transport"),
+		lastUpdates: make(map[string]time.Time),
+		now:         time.Now,
+	}
+}
+
+func (m *Metrics) Evaluated(doormanId string, c uint) {
+	m.evaluations.inc(doormanId, fmt.Sprint(c))
+}
+
+func (m *Metrics) UpdateAccepted(doormanId string) {
+	m.accepted.inc(doormanId)
+	m.mu.Lock()
+	m.lastUpdates[doormanId] = m.now()
+	m.mu.Unlock()
+}
+
+func (m *Metrics) UpdateRejected(doormanId, reason string) {
+	m.rejected.inc(doormanId, reason)
+}
+
+func (m *Metrics) SubscriberReconnected(doormanId, transport string) {
+	m.reconnects.inc(doormanId, transport)
+}
+
+func (m *Metrics) SubscriberError(doormanId, transport string, err error) {
+	m.errors.inc(doormanId, transport)
+}
+
+func (m *Metrics) writeLastUpdateAge(w io.Writer) {
+	const name = "doorman_last_update_age_seconds"
+	m.mu.Lock()
+	now := m.now()
+	lines := make([]string, 0, len(m.lastUpdates))
+	for id, t := range m.lastUpdates {
+		lines = append(lines, fmt.Sprintf("%v%v %v\n", name, formatLabels([]string{"doorman"}, []string{id}), now.Sub(t).Seconds()))
+	}
+	m.mu.Unlock()
+	sort.Strings(lines)
+	fmt.Fprintf(w, "# HELP %v Seconds since the last accepted update.\n# TYPE %v gauge\n", name, name)
+	for _, line := range lines {
+		io.WriteString(w, line)
+	}
+}
+
+// Write writes every metric in the Prometheus text format.
+func (m *Metrics) Write(w io.Writer) {
+	for _, c := range []*counter{m.evaluations, m.accepted, m.rejected, m.reconnects, m.errors} {
+		c.write(w)
+	}
+	m.writeLastUpdateAge(w)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	m.Write(w)
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+var _ shared.Instrumentation = New()
+
+func assertContains(t *testing.T, body, line string) {
+	if !strings.Contains(body, line+"\n") {
+		t.Error("missing line:", line)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	m := New()
+	start := time.Unix(1000, 0)
+	m.now = func() time.Time { return start }
+
+	m.Evaluated("foo", 0)
+	m.Evaluated("foo", 1)
+	m.Evaluated("foo", 1)
+	m.UpdateAccepted("foo")
+	m.UpdateRejected("foo", shared.RejectedBadSum)
+	m.UpdateRejected("bar", shared.RejectedStaleTimestamp)
+	m.SubscriberError("foo", "http", errors.New("down"))
+	m.SubscriberReconnected("foo", "http")
+
+	m.now = func() time.Time { return start.Add(90 * time.Second) }
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != contentType {
+		t.Error("bad content type", ct)
+	}
+	body := w.Body.String()
+	assertContains(t, body, "# TYPE doorman_evaluations_total counter")
+	assertContains(t, body, `doorman_evaluations_total{doorman="foo",case="0"} 1`)
+	assertContains(t, body, `doorman_evaluations_total{doorman="foo",case="1"} 2`)
+	assertContains(t, body, `doorman_updates_accepted_total{doorman="foo"} 1`)
+	assertContains(t, body, `doorman_updates_rejected_total{doorman="foo",reason="bad_sum"} 1`)
+	assertContains(t, body, `doorman_updates_rejected_total{doorman="bar",reason="stale_timestamp"} 1`)
+	assertContains(t, body, `doorman_subscriber_errors_total{doorman="foo",transport="http"} 1`)
+	assertContains(t, body, `doorman_subscriber_reconnects_total{doorman="foo",transport="http"} 1`)
+	assertContains(t, body, "# TYPE doorman_last_update_age_seconds gauge")
+	assertContains(t, body, `doorman_last_update_age_seconds{doorman="foo"} 90`)
+}
+
+func TestFormatLabels(t *testing.T) {
+	if l := formatLabels([]string{"a", "b"}, []string{`x"y`, "\\\n"}); l != `{a="x\"y",b="\\\n"}` {
+		t.Error("bad labels", l)
+	}
+}
@@ -1,24 +1,54 @@
 package nanomsgsubscriber
 
 import (
-	"encoding/json"
-	"errors"
-	"log"
+	"sync"
 
+	"github.com/didiercrunch/doorman/codec"
 	"github.com/didiercrunch/doorman/shared"
-	"github.com/go-mangos/mangos"
-	"github.com/go-mangos/mangos/protocol/sub"
-	"github.com/go-mangos/mangos/transport/ipc"
-	"github.com/go-mangos/mangos/transport/tcp"
 )
 
+const transport = "nanomsg"
+
+// NanoMsgSubscriber receives the updaters framed by Frame.  The subscribers of
+// a url share a single socket, subscribed to the topics of their doormen.
 type NanoMsgSubscriber struct {
-	Url string
+	Url             string
+	Instrumentation shared.Instrumentation // optional
+	Logger          shared.Logger          // optional
+	Resync          shared.ResyncFunc      // optional, called on a gap in the sequence numbers
+	Codec           codec.Codec            // optional, JSON by default
+	mu              sync.Mutex
+	subscriptions   []*subscription
+}
+
+func (s *NanoMsgSubscriber) codec() codec.Codec {
+	if s.Codec == nil {
+		return codec.JSON
+	}
+	return s.Codec
+}
+
+func (s *NanoMsgSubscriber) Transport() string {
+	return transport
+}
+
+func (s *NanoMsgSubscriber) logger() shared.Logger {
+	if s.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return s.Logger
+}
+
+func (s *NanoMsgSubscriber) instrumentation() shared.Instrumentation {
+	if s.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return s.Instrumentation
 }
 
 func (s *NanoMsgSubscriber) callUpdateHandlerFunction(f shared.UpdateHandlerFunc, data []byte) error {
 	wu := &shared.DoormanUpdater{}
-	if err := json.Unmarshal(data, wu); err != nil {
+	if err := s.codec().Unmarshal(data, wu); err != nil {
 		return err
 	} else {
 		return f(wu)
@@ -26,33 +56,28 @@ func (s *NanoMsgSubscriber) callUpdateHandlerFunction(f shared.UpdateHandlerFunc
 }
 
 func (s *NanoMsgSubscriber) Subscribe(abtestId string, update shared.UpdateHandlerFunc) error {
-	var sock mangos.Socket
-	var err error
-	var msg []byte
-
-	if sock, err = sub.NewSocket(); err != nil {
-		return errors.New("can't get new sub socket: " + err.Error())
-	}
-	sock.AddTransport(ipc.NewTransport())
-	sock.AddTransport(tcp.NewTransport())
-	if err = sock.Dial(s.Url); err != nil {
-		return errors.New("can't dial on sub socket: " + err.Error())
-	}
-	// Empty byte array effectively subscribes to everything
-	if err = sock.SetOption(mangos.OptionSubscribe, []byte("")); err != nil {
-		return errors.New("cannot subscribe: " + err.Error())
+	sub := &subscription{s: s, doormanId: abtestId}
+	sub.update = (&shared.GapDetector{Resync: s.Resync, Logger: s.Logger}).Wrap(update)
+	if err := subscribe(s.Url, sub); err != nil {
+		return err
 	}
-	go func(s *NanoMsgSubscriber, sock mangos.Socket) {
-		for {
-			if msg, err = sock.Recv(); err != nil {
-				err := errors.New("Cannot recv: " + err.Error())
-				log.Println(err)
-			} else if err := s.callUpdateHandlerFunction(update, msg); err != nil {
-				log.Println("cannot update abtest with received data: ", err)
-			}
-		}
-	}(s, sock)
-
+	s.mu.Lock()
+	s.subscriptions = append(s.subscriptions, sub)
+	s.mu.Unlock()
 	return nil
+}
 
+// Close ends every subscription, closing the sockets no other subscriber
+// uses.
+func (s *NanoMsgSubscriber) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret error
+	for _, sub := range s.subscriptions {
+		if err := unsubscribe(sub); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	s.subscriptions = nil
+	return ret
 }
@@ -2,8 +2,11 @@ package nanomsgsubscriber
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/didiercrunch/doorman/codec"
 	"github.com/didiercrunch/doorman/shared"
 )
 
@@ -25,3 +28,77 @@ func TestCallUpdateHandlerFunction(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// subscribeSequences subscribes to a doorman and returns the sequence numbers
+// applied, 0 for a resync.
+func subscribeSequences(t *testing.T, s *NanoMsgSubscriber, doormanId string) <-chan uint64 {
+	sequences := make(chan uint64, 10)
+	if err := s.Subscribe(doormanId, func(wu *shared.DoormanUpdater) error {
+		sequences <- wu.Sequence
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return sequences
+}
+
+func expectSequences(t *testing.T, sequences <-chan uint64, expected ...uint64) {
+	for _, e := range expected {
+		select {
+		case sequence := <-sequences:
+			if sequence != e {
+				t.Error("applied", sequence, "but expected", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for", e)
+		}
+	}
+}
+
+func TestGapDetection(t *testing.T) {
+	fakeDial(t)
+	resyncs := make(chan string, 10)
+	s := &NanoMsgSubscriber{Url: "tcp://gaps", Resync: func(doormanId string) (*shared.DoormanUpdater, error) {
+		resyncs <- doormanId
+		return &shared.DoormanUpdater{Id: doormanId}, nil
+	}}
+	defer s.Close()
+	foo, bar := subscribeSequences(t, s, "foo"), subscribeSequences(t, s, "bar")
+
+	// 3 is dropped, 5 and 4 are reordered on foo; bar shares the socket in order
+	for _, sequence := range []uint64{1, 2, 4, 6, 5} {
+		sendUpdater(t, "tcp://gaps", &shared.DoormanUpdater{Id: "foo", Sequence: sequence})
+	}
+	for _, sequence := range []uint64{1, 2} {
+		sendUpdater(t, "tcp://gaps", &shared.DoormanUpdater{Id: "bar", Sequence: sequence})
+	}
+	expectSequences(t, foo, 1, 2, 0, 4, 0, 6)
+	expectSequences(t, bar, 1, 2)
+	close(resyncs)
+	var resynced []string
+	for doormanId := range resyncs {
+		resynced = append(resynced, doormanId)
+	}
+	if fmt.Sprint(resynced) != "[foo foo]" {
+		t.Error("only foo should be resynchronized, twice", resynced)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	data, err := codec.MsgPack.Marshal(&shared.DoormanUpdater{Id: "foo", Timestamp: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := func(m *shared.DoormanUpdater) error {
+		if m.Id != "foo" || m.Timestamp != 4 {
+			t.Error("bad updater", m)
+		}
+		return nil
+	}
+	if err := (&NanoMsgSubscriber{Codec: codec.MsgPack}).callUpdateHandlerFunction(f, data); err != nil {
+		t.Error(err)
+	}
+	if err := new(NanoMsgSubscriber).callUpdateHandlerFunction(f, data); err == nil {
+		t.Error("msgpack should not decode as json")
+	}
+}
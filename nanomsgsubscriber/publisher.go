@@ -0,0 +1,98 @@
+package nanomsgsubscriber
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/go-mangos/mangos/protocol/pub"
+	"github.com/go-mangos/mangos/transport/ipc"
+	"github.com/go-mangos/mangos/transport/tcp"
+)
+
+// pubSocket is the part of a mangos socket used by the publishers.
+type pubSocket interface {
+	Send([]byte) error
+	Close() error
+}
+
+var listen = func(url string) (pubSocket, error) {
+	sock, err := pub.NewSocket()
+	if err != nil {
+		return nil, errors.New("can't get new pub socket: " + err.Error())
+	}
+	sock.AddTransport(ipc.NewTransport())
+	sock.AddTransport(tcp.NewTransport())
+	if err := sock.Listen(url); err != nil {
+		sock.Close()
+		return nil, errors.New("can't listen on pub socket: " + err.Error())
+	}
+	return sock, nil
+}
+
+// NanoMsgPublisher listens on Url, where the subscribers dial, and sends the
+// updaters framed by Frame.
+type NanoMsgPublisher struct {
+	Url   string
+	Codec codec.Codec // optional, JSON by default
+	mu    sync.Mutex
+	sock  pubSocket
+	seq   shared.Sequencer
+}
+
+func (p *NanoMsgPublisher) codec() codec.Codec {
+	if p.Codec == nil {
+		return codec.JSON
+	}
+	return p.Codec
+}
+
+// Listen opens the socket.  Publish listens on the first call otherwise.
+func (p *NanoMsgPublisher) Listen() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.listen()
+}
+
+// listen opens the socket unless open.  The caller holds p.mu.
+func (p *NanoMsgPublisher) listen() error {
+	if p.sock != nil {
+		return nil
+	}
+	sock, err := listen(p.Url)
+	if err != nil {
+		return err
+	}
+	p.sock = sock
+	return nil
+}
+
+// Publish sends wu numbered with the next sequence number of its doorman.
+func (p *NanoMsgPublisher) Publish(wu *shared.DoormanUpdater) error {
+	return p.seq.Publish(wu, p.publish)
+}
+
+func (p *NanoMsgPublisher) publish(wu *shared.DoormanUpdater) error {
+	data, err := p.codec().Marshal(wu)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.listen(); err != nil {
+		return err
+	}
+	return p.sock.Send(Frame(wu.Id, data))
+}
+
+func (p *NanoMsgPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sock == nil {
+		return nil
+	}
+	err := p.sock.Close()
+	p.sock = nil
+	return err
+}
@@ -0,0 +1,49 @@
+package nanomsgsubscriber
+
+import (
+	"testing"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// fakePubSocket hands the messages sent to the sub sockets of its url.
+type fakePubSocket struct {
+	url string
+}
+
+func (f *fakePubSocket) Send(msg []byte) error {
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	if ss, ok := sockets[f.url]; ok {
+		ss.sock.(*fakeSocket).messages <- msg
+	}
+	return nil
+}
+
+func (f *fakePubSocket) Close() error {
+	return nil
+}
+
+func TestNanoMsgPublisher(t *testing.T) {
+	fakeDial(t)
+	original := listen
+	listen = func(url string) (pubSocket, error) {
+		return &fakePubSocket{url}, nil
+	}
+	t.Cleanup(func() { listen = original })
+
+	s := &NanoMsgSubscriber{Url: "tcp://a", Codec: codec.MsgPack}
+	t.Cleanup(func() { s.Close() })
+	timestamps := subscribeTimestamps(t, s, "foo")
+	sequences := subscribeSequences(t, s, "bar")
+	p := &NanoMsgPublisher{Url: "tcp://a", Codec: codec.MsgPack}
+	defer p.Close()
+	for _, wu := range []*shared.DoormanUpdater{{Id: "bar", Timestamp: 1}, {Id: "foo", Timestamp: 2}, {Id: "bar", Timestamp: 3}} {
+		if err := p.Publish(wu); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectTimestamp(t, timestamps, 2)
+	expectSequences(t, sequences, 1, 2)
+}
@@ -0,0 +1,192 @@
+package nanomsgsubscriber
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/go-mangos/mangos"
+	"github.com/go-mangos/mangos/protocol/sub"
+	"github.com/go-mangos/mangos/transport/ipc"
+	"github.com/go-mangos/mangos/transport/tcp"
+)
+
+// separator ends the topic of a message, so that no doorman id prefixes the
+// topic of another doorman.
+const separator = ' '
+
+var ErrBadFrame = errors.New("message without topic")
+
+// Topic returns the prefix of the messages of a doorman.
+func Topic(doormanId string) []byte {
+	return append([]byte(doormanId), separator)
+}
+
+// Frame prefixes the encoded updater of a doorman with its topic.
+func Frame(doormanId string, data []byte) []byte {
+	return append(Topic(doormanId), data...)
+}
+
+// unframe splits a message into its doorman id and encoded updater.
+func unframe(msg []byte) (string, []byte, error) {
+	i := bytes.IndexByte(msg, separator)
+	if i < 0 {
+		return "", nil, ErrBadFrame
+	}
+	return string(msg[:i]), msg[i+1:], nil
+}
+
+// socket is the part of a mangos socket used by the subscribers.
+type socket interface {
+	Recv() ([]byte, error)
+	SetOption(name string, value interface{}) error
+	Close() error
+}
+
+var dial = func(url string) (socket, error) {
+	sock, err := sub.NewSocket()
+	if err != nil {
+		return nil, errors.New("can't get new sub socket: " + err.Error())
+	}
+	sock.AddTransport(ipc.NewTransport())
+	sock.AddTransport(tcp.NewTransport())
+	if err := sock.Dial(url); err != nil {
+		sock.Close()
+		return nil, errors.New("can't dial on sub socket: " + err.Error())
+	}
+	return sock, nil
+}
+
+type subscription struct {
+	s         *NanoMsgSubscriber
+	doormanId string
+	update    shared.UpdateHandlerFunc
+	socket    *sharedSocket
+}
+
+// sharedSocket dispatches the messages of a url to the subscriptions of its
+// doormen.
+type sharedSocket struct {
+	url           string
+	sock          socket
+	mu            sync.Mutex
+	subscriptions map[string][]*subscription
+}
+
+var (
+	socketsMu sync.Mutex
+	sockets   = make(map[string]*sharedSocket)
+)
+
+// subscribe adds a subscription to the socket of its url, dialing it if
+// needed.
+func subscribe(url string, s *subscription) error {
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	ss, ok := sockets[url]
+	if !ok {
+		sock, err := dial(url)
+		if err != nil {
+			return err
+		}
+		ss = &sharedSocket{url: url, sock: sock, subscriptions: make(map[string][]*subscription)}
+		sockets[url] = ss
+		go ss.receive()
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if len(ss.subscriptions[s.doormanId]) == 0 {
+		if err := ss.sock.SetOption(mangos.OptionSubscribe, Topic(s.doormanId)); err != nil {
+			ss.closeIfUnused()
+			return errors.New("cannot subscribe: " + err.Error())
+		}
+	}
+	ss.subscriptions[s.doormanId] = append(ss.subscriptions[s.doormanId], s)
+	s.socket = ss
+	return nil
+}
+
+// unsubscribe removes a subscription, closing its socket once it has none.
+func unsubscribe(s *subscription) error {
+	ss := s.socket
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	subscriptions := ss.subscriptions[s.doormanId]
+	for i, other := range subscriptions {
+		if other == s {
+			subscriptions = append(subscriptions[:i:i], subscriptions[i+1:]...)
+			break
+		}
+	}
+	if len(subscriptions) > 0 {
+		ss.subscriptions[s.doormanId] = subscriptions
+		return nil
+	}
+	delete(ss.subscriptions, s.doormanId)
+	if err := ss.sock.SetOption(mangos.OptionUnsubscribe, Topic(s.doormanId)); err != nil {
+		ss.closeIfUnused()
+		return err
+	}
+	return ss.closeIfUnused()
+}
+
+// closeIfUnused closes the socket without subscriptions.  The caller holds
+// socketsMu and ss.mu.
+func (ss *sharedSocket) closeIfUnused() error {
+	if len(ss.subscriptions) > 0 {
+		return nil
+	}
+	delete(sockets, ss.url)
+	return ss.sock.Close()
+}
+
+func (ss *sharedSocket) all() []*subscription {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var all []*subscription
+	for _, subscriptions := range ss.subscriptions {
+		all = append(all, subscriptions...)
+	}
+	return all
+}
+
+func (ss *sharedSocket) get(doormanId string) []*subscription {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return append([]*subscription(nil), ss.subscriptions[doormanId]...)
+}
+
+func (ss *sharedSocket) receive() {
+	failing := false
+	for {
+		msg, err := ss.sock.Recv()
+		if err == mangos.ErrClosed {
+			return
+		} else if err != nil {
+			for _, s := range ss.all() {
+				s.s.logger().Error("cannot receive", "doorman_id", s.doormanId, "transport", transport, "error", err)
+				s.s.instrumentation().SubscriberError(s.doormanId, transport, err)
+			}
+			failing = true
+			continue
+		}
+		if failing {
+			for _, s := range ss.all() {
+				s.s.instrumentation().SubscriberReconnected(s.doormanId, transport)
+			}
+			failing = false
+		}
+		doormanId, data, err := unframe(msg)
+		if err != nil {
+			continue
+		}
+		for _, s := range ss.get(doormanId) {
+			if err := s.s.callUpdateHandlerFunction(s.update, data); err != nil {
+				s.s.logger().Error("cannot update doorman with received data", "doorman_id", doormanId, "transport", transport, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package nanomsgsubscriber
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/go-mangos/mangos"
+)
+
+// fakeSocket filters the messages it receives by the prefixes subscribed, as
+// a sub socket does.
+type fakeSocket struct {
+	messages chan []byte
+	closed   chan bool
+	mu       sync.Mutex
+	topics   map[string]bool
+}
+
+func newFakeSocket() *fakeSocket {
+	return &fakeSocket{messages: make(chan []byte, 10), closed: make(chan bool), topics: make(map[string]bool)}
+}
+
+func (f *fakeSocket) subscribed(msg []byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for topic := range f.topics {
+		if bytes.HasPrefix(msg, []byte(topic)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeSocket) Recv() ([]byte, error) {
+	for {
+		select {
+		case msg := <-f.messages:
+			if f.subscribed(msg) {
+				return msg, nil
+			}
+		case <-f.closed:
+			return nil, mangos.ErrClosed
+		}
+	}
+}
+
+func (f *fakeSocket) SetOption(name string, value interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch name {
+	case mangos.OptionSubscribe:
+		f.topics[string(value.([]byte))] = true
+	case mangos.OptionUnsubscribe:
+		delete(f.topics, string(value.([]byte)))
+	}
+	return nil
+}
+
+func (f *fakeSocket) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func fakeDial(t *testing.T) *[]string {
+	var urls []string
+	original := dial
+	dial = func(url string) (socket, error) {
+		urls = append(urls, url)
+		return newFakeSocket(), nil
+	}
+	t.Cleanup(func() { dial = original })
+	return &urls
+}
+
+func send(t *testing.T, url, doormanId string, timestamp int64) {
+	sendUpdater(t, url, &shared.DoormanUpdater{Id: doormanId, Timestamp: timestamp})
+}
+
+// sendUpdater delivers the updater, framed and encoded in JSON, to the fake
+// socket of url.
+func sendUpdater(t *testing.T, url string, wu *shared.DoormanUpdater) {
+	data, err := json.Marshal(wu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	sockets[url].sock.(*fakeSocket).messages <- Frame(wu.Id, data)
+}
+
+func subscribeTimestamps(t *testing.T, s *NanoMsgSubscriber, doormanId string) <-chan int64 {
+	timestamps := make(chan int64, 10)
+	if err := s.Subscribe(doormanId, func(wu *shared.DoormanUpdater) error {
+		if wu.Id != doormanId {
+			t.Error("received the updater of", wu.Id, "for", doormanId)
+		}
+		timestamps <- wu.Timestamp
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return timestamps
+}
+
+func expectTimestamp(t *testing.T, timestamps <-chan int64, expected int64) {
+	select {
+	case timestamp := <-timestamps:
+		if timestamp != expected {
+			t.Error("received", timestamp, "but expected", expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for", expected)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	doormanId, data, err := unframe(Frame("foo", []byte("{} ")))
+	if err != nil || doormanId != "foo" || string(data) != "{} " {
+		t.Error("bad frame", doormanId, string(data), err)
+	}
+	if _, _, err := unframe([]byte("{}")); err != ErrBadFrame {
+		t.Error("expected a bad frame", err)
+	}
+}
+
+func TestSharedSocket(t *testing.T) {
+	urls := fakeDial(t)
+	foo, foobar := &NanoMsgSubscriber{Url: "tcp://a"}, &NanoMsgSubscriber{Url: "tcp://a"}
+	fooTimestamps := subscribeTimestamps(t, foo, "foo")
+	foobarTimestamps := subscribeTimestamps(t, foobar, "foobar")
+	if len(*urls) != 1 {
+		t.Fatal("the subscribers of a url should share a socket", *urls)
+	}
+
+	// the message of bar is filtered out and the one of foobar only reaches
+	// its doorman even though foo prefixes it
+	send(t, "tcp://a", "bar", 1)
+	send(t, "tcp://a", "foobar", 2)
+	send(t, "tcp://a", "foo", 3)
+	expectTimestamp(t, foobarTimestamps, 2)
+	expectTimestamp(t, fooTimestamps, 3)
+
+	if err := foo.Close(); err != nil {
+		t.Fatal(err)
+	}
+	socketsMu.Lock()
+	sock := sockets["tcp://a"].sock.(*fakeSocket)
+	socketsMu.Unlock()
+	if sock.subscribed(Topic("foo")) || !sock.subscribed(Topic("foobar")) {
+		t.Error("only foobar should remain subscribed", sock.topics)
+	}
+	if err := foobar.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sock.closed:
+	default:
+		t.Error("an unused socket should be closed")
+	}
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	if len(sockets) != 0 {
+		t.Error("an unused socket should be forgotten", sockets)
+	}
+}
@@ -0,0 +1,158 @@
+// Package natssubscriber receives the doorman updaters on a NATS subject per
+// doorman.
+package natssubscriber
+
+import (
+	"sync"
+	"time"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/nats-io/nats.go"
+)
+
+const transport = "nats"
+
+const defaultSubjectPrefix = "doorman."
+
+// NATSSubscriber receives the updaters of a doorman on the subject
+// SubjectPrefix+id.  When Stream names the JetStream stream retaining these
+// subjects, the last updater of the doorman is read from it when subscribing
+// and after every reconnection, since core NATS drops the messages published
+// while disconnected.
+type NATSSubscriber struct {
+	Url             string
+	SubjectPrefix   string                 // optional, "doorman." by default
+	Stream          string                 // optional, the JetStream stream of the last updaters
+	Codec           codec.Codec            // optional, JSON by default
+	ReconnectWait   time.Duration          // optional, the delay between reconnections, two seconds by default
+	Instrumentation shared.Instrumentation // optional
+	Logger          shared.Logger          // optional
+	Resync          shared.ResyncFunc      // optional, called on a gap in the sequence numbers
+	mu              sync.Mutex
+	conns           []*nats.Conn
+}
+
+func (s *NATSSubscriber) Transport() string {
+	return transport
+}
+
+func (s *NATSSubscriber) instrumentation() shared.Instrumentation {
+	if s.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+func (s *NATSSubscriber) logger() shared.Logger {
+	if s.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return s.Logger
+}
+
+func (s *NATSSubscriber) codec() codec.Codec {
+	if s.Codec == nil {
+		return codec.JSON
+	}
+	return s.Codec
+}
+
+func subject(prefix, doormanId string) string {
+	if prefix == "" {
+		return defaultSubjectPrefix + doormanId
+	}
+	return prefix + doormanId
+}
+
+func (s *NATSSubscriber) subject(doormanId string) string {
+	return subject(s.SubjectPrefix, doormanId)
+}
+
+func (s *NATSSubscriber) callUpdateHandlerFunction(f shared.UpdateHandlerFunc, data []byte) error {
+	wu := &shared.DoormanUpdater{}
+	if err := s.codec().Unmarshal(data, wu); err != nil {
+		return err
+	}
+	return f(wu)
+}
+
+func (s *NATSSubscriber) Subscribe(doormanId string, update shared.UpdateHandlerFunc) error {
+	update = (&shared.GapDetector{Resync: s.Resync, Logger: s.Logger}).Wrap(update)
+	options := []nats.Option{
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			if err != nil {
+				s.logger().Error("disconnected", "doorman_id", doormanId, "transport", transport, "error", err)
+				s.instrumentation().SubscriberError(doormanId, transport, err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			s.logger().Info("reconnected", "doorman_id", doormanId, "transport", transport)
+			s.instrumentation().SubscriberReconnected(doormanId, transport)
+			// the handlers of a connection run one at a time, out of its
+			// reading loop
+			go func() {
+				if err := s.replay(nc, doormanId, update); err != nil {
+					s.logger().Error("cannot replay the last update", "doorman_id", doormanId, "transport", transport, "error", err)
+				}
+			}()
+		}),
+	}
+	if s.ReconnectWait != 0 {
+		options = append(options, nats.ReconnectWait(s.ReconnectWait))
+	}
+	nc, err := nats.Connect(s.Url, options...)
+	if err != nil {
+		return err
+	}
+	_, err = nc.Subscribe(s.subject(doormanId), func(msg *nats.Msg) {
+		if err := s.callUpdateHandlerFunction(update, msg.Data); err != nil {
+			s.logger().Error("cannot update doorman with received data", "doorman_id", doormanId, "transport", transport, "error", err)
+		}
+	})
+	// subscribe before replaying so no change is missed in between
+	if err == nil {
+		err = nc.Flush()
+	}
+	if err == nil {
+		err = s.replay(nc, doormanId, update)
+	}
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, nc)
+	s.mu.Unlock()
+	return nil
+}
+
+// replay updates the doorman with the last updater of the stream, if any.
+func (s *NATSSubscriber) replay(nc *nats.Conn, doormanId string, update shared.UpdateHandlerFunc) error {
+	if s.Stream == "" {
+		return nil
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+	msg, err := js.GetLastMsg(s.Stream, s.subject(doormanId))
+	if err == nats.ErrMsgNotFound {
+		s.logger().Info("no update to replay", "doorman_id", doormanId, "transport", transport, "stream", s.Stream)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.callUpdateHandlerFunction(update, msg.Data)
+}
+
+// Close ends every subscription.
+func (s *NATSSubscriber) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, nc := range s.conns {
+		nc.Close()
+	}
+	s.conns = nil
+}
@@ -0,0 +1,226 @@
+package natssubscriber
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// testServer is a nats server in process, with a JetStream stream retaining
+// the last message of the subjects of the tests.
+type testServer struct {
+	t      *testing.T
+	stream string
+	opts   server.Options
+	srv    *server.Server
+}
+
+func startTestServer(t *testing.T, stream string) *testServer {
+	s := &testServer{t: t, stream: stream, opts: server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	}}
+	s.start()
+	t.Cleanup(s.stop)
+	// restarted on the same port
+	s.opts.Port = s.srv.Addr().(*net.TCPAddr).Port
+	s.jetStream(s.srv, func(js nats.JetStreamContext) error {
+		_, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{"doorman.>", "flags.>"}, MaxMsgsPerSubject: 1})
+		return err
+	})
+	return s
+}
+
+func (s *testServer) url() string {
+	return s.srv.ClientURL()
+}
+
+func (s *testServer) run(opts server.Options) *server.Server {
+	srv, err := server.NewServer(&opts)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		s.t.Fatal("nats server not ready")
+	}
+	return srv
+}
+
+func (s *testServer) start() {
+	s.srv = s.run(s.opts)
+}
+
+func (s *testServer) stop() {
+	s.srv.Shutdown()
+	s.srv.WaitForShutdown()
+}
+
+// jetStream calls f with the JetStream of srv.
+func (s *testServer) jetStream(srv *server.Server, f func(js nats.JetStreamContext) error) {
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err == nil {
+		err = f(js)
+	}
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// publish publishes data on subject, once retained by the stream.
+func (s *testServer) publish(subject string, data []byte) {
+	s.jetStream(s.srv, func(js nats.JetStreamContext) error {
+		_, err := js.Publish(subject, data)
+		return err
+	})
+}
+
+// setLast stores data as the last message of subject while s is stopped,
+// through another server on its storage, so no subscriber receives it.
+func (s *testServer) setLast(subject string, data []byte) {
+	opts := s.opts
+	opts.Port = server.RANDOM_PORT
+	srv := s.run(opts)
+	defer func() {
+		srv.Shutdown()
+		srv.WaitForShutdown()
+	}()
+	s.jetStream(srv, func(js nats.JetStreamContext) error {
+		_, err := js.Publish(subject, data)
+		return err
+	})
+}
+
+func encode(t *testing.T, wu *shared.DoormanUpdater) []byte {
+	data, err := codec.JSON.Marshal(wu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// subscribe returns the channel of the timestamps of the updates.
+func subscribe(t *testing.T, s *NATSSubscriber, doormanId string) <-chan int64 {
+	timestamps := make(chan int64, 10)
+	if err := s.Subscribe(doormanId, func(wu *shared.DoormanUpdater) error {
+		timestamps <- wu.Timestamp
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return timestamps
+}
+
+func expectTimestamp(t *testing.T, timestamps <-chan int64, expected int64) {
+	select {
+	case timestamp := <-timestamps:
+		if timestamp != expected {
+			t.Error("received timestamp", timestamp, "but expected", expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for timestamp", expected)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	server := startTestServer(t, "DOORMEN")
+	timestamps := subscribe(t, &NATSSubscriber{Url: server.url()}, "foo")
+	server.publish("doorman.foo", encode(t, &shared.DoormanUpdater{Id: "foo", Timestamp: 1}))
+	server.publish("doorman.bar", encode(t, &shared.DoormanUpdater{Id: "bar", Timestamp: 2}))
+	expectTimestamp(t, timestamps, 1)
+	select {
+	case timestamp := <-timestamps:
+		t.Error("received an update of another doorman", timestamp)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReplay(t *testing.T) {
+	server := startTestServer(t, "DOORMEN")
+	server.publish("flags.foo", encode(t, &shared.DoormanUpdater{Id: "foo", Timestamp: 1}))
+	timestamps := subscribe(t, &NATSSubscriber{Url: server.url(), SubjectPrefix: "flags.", Stream: "DOORMEN"}, "foo")
+	expectTimestamp(t, timestamps, 1)
+
+	// nothing to replay
+	subscribe(t, &NATSSubscriber{Url: server.url(), Stream: "DOORMEN"}, "bar")
+}
+
+func TestGapDetection(t *testing.T) {
+	server := startTestServer(t, "DOORMEN")
+	resyncs := make(chan string, 10)
+	s := &NATSSubscriber{Url: server.url(), Resync: func(doormanId string) (*shared.DoormanUpdater, error) {
+		resyncs <- doormanId
+		return &shared.DoormanUpdater{Id: doormanId, Timestamp: 100}, nil
+	}}
+	timestamps := subscribe(t, s, "foo")
+
+	// 2 is missing, then delivered late
+	for _, sequence := range []uint64{1, 3, 2, 4} {
+		server.publish("doorman.foo", encode(t, &shared.DoormanUpdater{Id: "foo", Timestamp: int64(sequence), Sequence: sequence}))
+	}
+	for _, timestamp := range []int64{1, 100, 3, 4} {
+		expectTimestamp(t, timestamps, timestamp)
+	}
+	if doormanId := <-resyncs; doormanId != "foo" {
+		t.Error("resynchronized the wrong doorman", doormanId)
+	}
+	if len(resyncs) != 0 {
+		t.Error("resynchronized too often")
+	}
+}
+
+type recordingInstrumentation struct {
+	shared.NopInstrumentation
+	mu         sync.Mutex
+	errors     int
+	reconnects int
+}
+
+func (i *recordingInstrumentation) SubscriberError(doormanId, transport string, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.errors++
+}
+
+func (i *recordingInstrumentation) SubscriberReconnected(doormanId, transport string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.reconnects++
+}
+
+func TestReconnect(t *testing.T) {
+	server := startTestServer(t, "DOORMEN")
+	i := new(recordingInstrumentation)
+	s := &NATSSubscriber{Url: server.url(), Stream: "DOORMEN", ReconnectWait: 10 * time.Millisecond, Instrumentation: i}
+	timestamps := subscribe(t, s, "foo")
+
+	server.stop()
+	// published while disconnected
+	server.setLast("doorman.foo", encode(t, &shared.DoormanUpdater{Id: "foo", Timestamp: 2}))
+	server.start()
+	expectTimestamp(t, timestamps, 2)
+
+	server.publish("doorman.foo", encode(t, &shared.DoormanUpdater{Id: "foo", Timestamp: 3}))
+	expectTimestamp(t, timestamps, 3)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.errors != 1 || i.reconnects != 1 {
+		t.Error("bad instrumentation", i.errors, i.reconnects)
+	}
+}
@@ -0,0 +1,71 @@
+package natssubscriber
+
+import (
+	"sync"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes the updater of a doorman on the subject
+// SubjectPrefix+id.  A JetStream stream retaining these subjects keeps the
+// last updaters for the subscribers replaying them.
+type NATSPublisher struct {
+	Url           string
+	SubjectPrefix string      // optional, "doorman." by default
+	Codec         codec.Codec // optional, JSON by default
+	mu            sync.Mutex
+	conn          *nats.Conn
+	seq           shared.Sequencer
+}
+
+func (p *NATSPublisher) codec() codec.Codec {
+	if p.Codec == nil {
+		return codec.JSON
+	}
+	return p.Codec
+}
+
+func (p *NATSPublisher) connect() (*nats.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		conn, err := nats.Connect(p.Url, nats.MaxReconnects(-1))
+		if err != nil {
+			return nil, err
+		}
+		p.conn = conn
+	}
+	return p.conn, nil
+}
+
+// Publish sends wu numbered with the next sequence number of its doorman and
+// returns once the server received it.
+func (p *NATSPublisher) Publish(wu *shared.DoormanUpdater) error {
+	return p.seq.Publish(wu, p.publish)
+}
+
+func (p *NATSPublisher) publish(wu *shared.DoormanUpdater) error {
+	data, err := p.codec().Marshal(wu)
+	if err != nil {
+		return err
+	}
+	nc, err := p.connect()
+	if err != nil {
+		return err
+	}
+	if err := nc.Publish(subject(p.SubjectPrefix, wu.Id), data); err != nil {
+		return err
+	}
+	return nc.Flush()
+}
+
+func (p *NATSPublisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -0,0 +1,47 @@
+package natssubscriber
+
+import (
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestNATSPublisher(t *testing.T) {
+	server := startTestServer(t, "DOORMAN")
+	timestamps := subscribe(t, &NATSSubscriber{Url: server.url(), SubjectPrefix: "flags.", Codec: codec.MsgPack}, "foo")
+	p := &NATSPublisher{Url: server.url(), SubjectPrefix: "flags.", Codec: codec.MsgPack}
+	defer p.Close()
+	if err := p.Publish(&shared.DoormanUpdater{Id: "foo", Timestamp: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expectTimestamp(t, timestamps, 1)
+
+	// the updaters of a doorman are numbered
+	sequences := make(chan uint64, 10)
+	s := &NATSSubscriber{Url: server.url(), SubjectPrefix: "flags.", Codec: codec.MsgPack}
+	if err := s.Subscribe("foo", func(wu *shared.DoormanUpdater) error {
+		sequences <- wu.Sequence
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	if err := p.Publish(&shared.DoormanUpdater{Id: "foo", Timestamp: 2}); err != nil {
+		t.Fatal(err)
+	}
+	expectTimestamp(t, timestamps, 2)
+	select {
+	case sequence := <-sequences:
+		if sequence != 2 {
+			t.Error("bad sequence", sequence)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the sequence")
+	}
+
+	// the stream replays the last updater to a late subscriber
+	s = &NATSSubscriber{Url: server.url(), SubjectPrefix: "flags.", Stream: "DOORMAN", Codec: codec.MsgPack}
+	expectTimestamp(t, subscribe(t, s, "foo"), 2)
+}
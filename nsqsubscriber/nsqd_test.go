@@ -0,0 +1,138 @@
+package nsqsubscriber
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testNSQD speaks enough of the protocol of nsqd for a consumer and a
+// producer, behind an nsqlookupd answering every lookup with it.
+type testNSQD struct {
+	t         *testing.T
+	ln        net.Listener
+	lookupd   *httptest.Server
+	mu        sync.Mutex
+	conns     map[net.Conn]bool
+	subs      chan string // the topic and channel of every SUB
+	commands  chan string // the FIN and REQ commands
+	published chan string // the topics of the PUB commands
+	messages  int
+}
+
+func startTestNSQD(t *testing.T) *testNSQD {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testNSQD{t: t, ln: ln, conns: make(map[net.Conn]bool), subs: make(chan string, 10), commands: make(chan string, 10), published: make(chan string, 10)}
+	d.lookupd = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-NSQ-Content-Type", "nsq; version=1.0")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"channels":  []string{},
+			"producers": []map[string]interface{}{{"broadcast_address": "127.0.0.1", "tcp_port": ln.Addr().(*net.TCPAddr).Port}},
+		})
+	}))
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	t.Cleanup(d.stop)
+	return d
+}
+
+func (d *testNSQD) stop() {
+	d.lookupd.Close()
+	d.ln.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for conn := range d.conns {
+		conn.Close()
+	}
+}
+
+// frame writes a frame of type frameType.  The caller holds d.mu.
+func frame(w io.Writer, frameType int32, data []byte) {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)+4))
+	binary.BigEndian.PutUint32(header[4:], uint32(frameType))
+	w.Write(append(header, data...))
+}
+
+// publish sends a message to every subscribed connection.  The consumers
+// connect asynchronously so the subscriptions should be awaited on subs.
+func (d *testNSQD) publish(body []byte, timestamp int64, attempts uint16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.messages++
+	data := make([]byte, 26, 26+len(body))
+	binary.BigEndian.PutUint64(data, uint64(timestamp))
+	binary.BigEndian.PutUint16(data[8:], attempts)
+	copy(data[10:], fmt.Sprintf("%016d", d.messages))
+	for conn := range d.conns {
+		frame(conn, 2, append(data, body...))
+	}
+}
+
+func (d *testNSQD) respond(conn net.Conn, data string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	frame(conn, 0, []byte(data))
+}
+
+func (d *testNSQD) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if _, err := io.ReadFull(r, make([]byte, 4)); err != nil {
+		return
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		switch args[0] {
+		case "IDENTIFY":
+			size := make([]byte, 4)
+			io.ReadFull(r, size)
+			io.ReadFull(r, make([]byte, binary.BigEndian.Uint32(size)))
+			d.respond(conn, "OK")
+		case "SUB":
+			d.mu.Lock()
+			d.conns[conn] = true
+			d.mu.Unlock()
+			d.respond(conn, "OK")
+			d.subs <- args[1] + " " + args[2]
+		case "PUB":
+			size := make([]byte, 4)
+			io.ReadFull(r, size)
+			body := make([]byte, binary.BigEndian.Uint32(size))
+			io.ReadFull(r, body)
+			d.respond(conn, "OK")
+			d.published <- args[1]
+			d.publish(body, time.Now().UnixNano(), 1)
+		case "FIN", "REQ":
+			d.commands <- args[0]
+		case "CLS":
+			d.mu.Lock()
+			delete(d.conns, conn)
+			d.mu.Unlock()
+			d.respond(conn, "CLOSE_WAIT")
+		}
+	}
+}
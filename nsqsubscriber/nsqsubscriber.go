@@ -1,39 +1,171 @@
 package nsqsubscriber
 
 import (
-	"encoding/json"
+	"context"
+	"strings"
+	"sync"
 
 	"github.com/pborman/uuid"
 	"github.com/bitly/go-nsq"
+	"github.com/didiercrunch/doorman/codec"
 	"github.com/didiercrunch/doorman/shared"
 )
 
-var UUID = uuid.New()
+const transport = "nsq"
 
+// ErrorPolicy tells what to do with a message failing to update the doorman.
+type ErrorPolicy int
+
+const (
+	// Finish drops the message, since an update rejected by the doorman
+	// would be rejected again.
+	Finish ErrorPolicy = iota
+	// Requeue requeues the message until nsq gives up after MaxAttempts.
+	Requeue
+)
+
+// NSQSubscriber consumes the updaters published on the topic of a doorman.
+// Unless TrustPayloadTimestamp is set, the timestamp of an updater is the one
+// nsq gives the message: two updates published in the same tick of its clock
+// then have the same timestamp and the second one is dropped as stale, so the
+// subscribers of an NSQPublisher, whose updaters keep the timestamps of the
+// server, should trust them.
 type NSQSubscriber struct {
-	NSQLookupURL string
+	NSQLookupURL          string
+	Channel               string                 // optional, an ephemeral channel unique to the subscriber by default
+	TrustPayloadTimestamp bool                   // when true, the timestamp of the updater is kept instead of the one of nsq
+	OnError               ErrorPolicy            // optional, Finish by default; messages failing to decode are always finished
+	MaxAttempts           uint16                 // optional, the attempts of a requeued message, nsq's default when 0
+	Instrumentation       shared.Instrumentation // optional
+	Logger                shared.Logger          // optional, also receives the logs of the nsq consumer
+	Resync                shared.ResyncFunc      // optional, called on a gap in the sequence numbers
+	Codec                 codec.Codec            // optional, JSON by default
+	mu                    sync.Mutex
+	ephemeral             string
+	consumers             []*nsq.Consumer
+}
+
+func (sub *NSQSubscriber) codec() codec.Codec {
+	if sub.Codec == nil {
+		return codec.JSON
+	}
+	return sub.Codec
+}
+
+func (sub *NSQSubscriber) channel() string {
+	if sub.Channel != "" {
+		return sub.Channel
+	}
+	// deleted by nsqd once the subscriber disconnects
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.ephemeral == "" {
+		sub.ephemeral = uuid.New() + "#ephemeral"
+	}
+	return sub.ephemeral
+}
+
+func (sub *NSQSubscriber) Transport() string {
+	return transport
 }
 
-func toNSQHandlerFunc(update shared.UpdateHandlerFunc) nsq.HandlerFunc {
+func (sub *NSQSubscriber) logger() shared.Logger {
+	if sub.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return sub.Logger
+}
+
+// nsqLogger adapts a shared.Logger to the logger of the nsq consumer.
+type nsqLogger struct {
+	logger    shared.Logger
+	doormanId string
+}
+
+func (l *nsqLogger) Output(calldepth int, s string) error {
+	l.logger.Info(strings.TrimSpace(s), "doorman_id", l.doormanId, "transport", transport)
+	return nil
+}
+
+func (sub *NSQSubscriber) instrumentation() shared.Instrumentation {
+	if sub.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return sub.Instrumentation
+}
+
+func (sub *NSQSubscriber) failed(doormanId, msg string, err error) {
+	sub.logger().Error(msg, "doorman_id", doormanId, "transport", transport, "error", err)
+	sub.instrumentation().SubscriberError(doormanId, transport, err)
+}
+
+// handlerFunc hands the messages to update.  nsq finishes a message when the
+// handler returns nil and requeues it otherwise.
+func (sub *NSQSubscriber) handlerFunc(doormanId string, update shared.UpdateHandlerFunc) nsq.HandlerFunc {
+	c := sub.codec()
 	return func(message *nsq.Message) error {
 		wu := &shared.DoormanUpdater{}
-		if err := json.Unmarshal(message.Body, wu); err != nil {
-			return err
+		if err := c.Unmarshal(message.Body, wu); err != nil {
+			sub.failed(doormanId, "cannot decode message", err)
+			return nil
+		}
+		if !sub.TrustPayloadTimestamp {
+			wu.Timestamp = message.Timestamp
+		}
+		if err := update(wu); err != nil {
+			sub.failed(doormanId, "cannot handle message", err)
+			if sub.OnError == Requeue {
+				return err
+			}
 		}
-		wu.Timestamp = message.Timestamp
-		return update(wu)
+		return nil
 	}
 }
 
 func (sub *NSQSubscriber) Subscribe(doormanId string, update shared.UpdateHandlerFunc) error {
+	return sub.SubscribeContext(context.Background(), doormanId, update)
+}
+
+// SubscribeContext subscribes until ctx is done or Stop is called.
+func (sub *NSQSubscriber) SubscribeContext(ctx context.Context, doormanId string, update shared.UpdateHandlerFunc) error {
 	config := nsq.NewConfig()
-	q, err := nsq.NewConsumer(doormanId, UUID, config)
+	if sub.MaxAttempts != 0 {
+		config.MaxAttempts = sub.MaxAttempts
+	}
+	q, err := nsq.NewConsumer(doormanId, sub.channel(), config)
 	if err != nil {
 		return err
 	}
-	q.AddHandler(nsq.HandlerFunc(toNSQHandlerFunc(update)))
+	q.SetLogger(&nsqLogger{sub.logger(), doormanId}, nsq.LogLevelInfo)
+	update = (&shared.GapDetector{Resync: sub.Resync, Logger: sub.Logger}).Wrap(update)
+	q.AddHandler(sub.handlerFunc(doormanId, update))
 	if err := q.ConnectToNSQLookupd(sub.NSQLookupURL); err != nil {
+		q.Stop()
 		return err
 	}
+	sub.mu.Lock()
+	sub.consumers = append(sub.consumers, q)
+	sub.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.Stop()
+		case <-q.StopChan:
+		}
+	}()
 	return nil
 }
+
+// Stop stops every consumer and waits for their messages in flight.
+func (sub *NSQSubscriber) Stop() {
+	sub.mu.Lock()
+	consumers := sub.consumers
+	sub.consumers = nil
+	sub.mu.Unlock()
+	for _, q := range consumers {
+		q.Stop()
+	}
+	for _, q := range consumers {
+		<-q.StopChan
+	}
+}
@@ -1,9 +1,16 @@
 package nsqsubscriber
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bitly/go-nsq"
+	"github.com/didiercrunch/doorman/shared"
 )
 
 func TestNSQMessage(t *testing.T) {
@@ -17,3 +24,146 @@ func TestNSQMessage(t *testing.T) {
 		t.Error()
 	}
 }
+
+// subscribe returns the channel of the timestamps of the updates, failing the
+// updates when fail is true.
+func subscribe(t *testing.T, sub *NSQSubscriber, fail bool) <-chan int64 {
+	timestamps := make(chan int64, 10)
+	if err := sub.Subscribe("foo", func(wu *shared.DoormanUpdater) error {
+		timestamps <- wu.Timestamp
+		if fail {
+			return errors.New("rejected")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Stop)
+	return timestamps
+}
+
+func waitFor[T any](t *testing.T, c <-chan T) T {
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	panic("unreachable")
+}
+
+func publish(t *testing.T, d *testNSQD, wu *shared.DoormanUpdater, timestamp int64) {
+	body, err := json.Marshal(wu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.publish(body, timestamp, 1)
+}
+
+func TestSubscribe(t *testing.T) {
+	d := startTestNSQD(t)
+	sub := &NSQSubscriber{NSQLookupURL: d.lookupd.URL}
+	timestamps := subscribe(t, sub, false)
+	if s := waitFor(t, d.subs); s != "foo "+sub.channel() || !strings.HasSuffix(s, "#ephemeral") {
+		t.Error("bad subscription", s)
+	}
+	if sub.channel() == (&NSQSubscriber{}).channel() {
+		t.Error("the ephemeral channels should be unique to the subscriber")
+	}
+	publish(t, d, &shared.DoormanUpdater{Id: "foo", Timestamp: 5}, 7)
+	if timestamp := waitFor(t, timestamps); timestamp != 7 {
+		t.Error("the timestamp of nsq should be used", timestamp)
+	}
+	if command := waitFor(t, d.commands); command != "FIN" {
+		t.Error("the message should be finished", command)
+	}
+}
+
+func TestSubscribeOptions(t *testing.T) {
+	d := startTestNSQD(t)
+	timestamps := subscribe(t, &NSQSubscriber{NSQLookupURL: d.lookupd.URL, Channel: "bar", TrustPayloadTimestamp: true}, false)
+	if s := waitFor(t, d.subs); s != "foo bar" {
+		t.Error("bad subscription", s)
+	}
+	publish(t, d, &shared.DoormanUpdater{Id: "foo", Timestamp: 5}, 7)
+	if timestamp := waitFor(t, timestamps); timestamp != 5 {
+		t.Error("the timestamp of the payload should be used", timestamp)
+	}
+}
+
+func TestErrorPolicy(t *testing.T) {
+	for policy, expected := range map[ErrorPolicy]string{Finish: "FIN", Requeue: "REQ"} {
+		d := startTestNSQD(t)
+		timestamps := subscribe(t, &NSQSubscriber{NSQLookupURL: d.lookupd.URL, OnError: policy}, true)
+		waitFor(t, d.subs)
+		publish(t, d, &shared.DoormanUpdater{Id: "foo"}, 1)
+		waitFor(t, timestamps)
+		if command := waitFor(t, d.commands); command != expected {
+			t.Error("received", command, "but expected", expected)
+		}
+
+		// a message failing to decode is always finished
+		d.publish([]byte("not json"), 2, 1)
+		if command := waitFor(t, d.commands); command != "FIN" {
+			t.Error("a bad message should be finished", command)
+		}
+	}
+}
+
+func TestGapDetection(t *testing.T) {
+	d := startTestNSQD(t)
+	resyncs := make(chan string, 10)
+	sub := &NSQSubscriber{NSQLookupURL: d.lookupd.URL, Resync: func(doormanId string) (*shared.DoormanUpdater, error) {
+		resyncs <- doormanId
+		return &shared.DoormanUpdater{Id: doormanId}, nil
+	}}
+	sequences := make(chan uint64, 10)
+	if err := sub.Subscribe("foo", func(wu *shared.DoormanUpdater) error {
+		sequences <- wu.Sequence
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Stop)
+	waitFor(t, d.subs)
+
+	// 2 is dropped and 3 is delivered again late
+	for i, sequence := range []uint64{1, 3, 4, 5, 3} {
+		publish(t, d, &shared.DoormanUpdater{Id: "foo", Sequence: sequence}, int64(i+1))
+	}
+	var applied []uint64
+	for i := 0; i < 5; i++ {
+		applied = append(applied, waitFor(t, sequences))
+	}
+	if fmt.Sprint(applied) != "[1 0 3 4 5]" {
+		t.Error("bad gap detection", applied)
+	}
+	if doormanId := waitFor(t, resyncs); doormanId != "foo" {
+		t.Error("resynchronized the wrong doorman", doormanId)
+	}
+	if len(resyncs) != 0 {
+		t.Error("the doorman should be resynchronized once")
+	}
+}
+
+func TestStop(t *testing.T) {
+	d := startTestNSQD(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &NSQSubscriber{NSQLookupURL: d.lookupd.URL}
+	if err := sub.SubscribeContext(ctx, "foo", func(wu *shared.DoormanUpdater) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	q := sub.consumers[0]
+	waitFor(t, d.subs)
+	cancel()
+	waitFor(t, q.StopChan)
+
+	sub.Subscribe("foo", func(wu *shared.DoormanUpdater) error { return nil })
+	waitFor(t, d.subs)
+	done := make(chan bool)
+	go func() {
+		sub.Stop()
+		close(done)
+	}()
+	waitFor(t, done)
+}
@@ -0,0 +1,68 @@
+package nsqsubscriber
+
+import (
+	"sync"
+
+	"github.com/bitly/go-nsq"
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// NSQPublisher publishes the updater of a doorman on the topic named by its
+// id, through the nsqd at NSQDAddress.  The timestamps of the updaters are
+// kept, so its subscribers should set TrustPayloadTimestamp.
+type NSQPublisher struct {
+	NSQDAddress string
+	Codec       codec.Codec   // optional, JSON by default
+	Logger      shared.Logger // optional, receives the logs of the nsq producer
+	mu          sync.Mutex
+	producer    *nsq.Producer
+	seq         shared.Sequencer
+}
+
+func (p *NSQPublisher) codec() codec.Codec {
+	if p.Codec == nil {
+		return codec.JSON
+	}
+	return p.Codec
+}
+
+func (p *NSQPublisher) logger() shared.Logger {
+	if p.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return p.Logger
+}
+
+// Publish sends wu numbered with the next sequence number of its doorman.
+func (p *NSQPublisher) Publish(wu *shared.DoormanUpdater) error {
+	return p.seq.Publish(wu, p.publish)
+}
+
+func (p *NSQPublisher) publish(wu *shared.DoormanUpdater) error {
+	data, err := p.codec().Marshal(wu)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if p.producer == nil {
+		if p.producer, err = nsq.NewProducer(p.NSQDAddress, nsq.NewConfig()); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+		// the producer publishes the updaters of every doorman
+		p.producer.SetLogger(&nsqLogger{p.logger(), "*"}, nsq.LogLevelInfo)
+	}
+	producer := p.producer
+	p.mu.Unlock()
+	return producer.Publish(wu.Id, data)
+}
+
+func (p *NSQPublisher) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.producer != nil {
+		p.producer.Stop()
+		p.producer = nil
+	}
+}
@@ -0,0 +1,34 @@
+package nsqsubscriber
+
+import (
+	"testing"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestNSQPublisher(t *testing.T) {
+	d := startTestNSQD(t)
+	updaters := make(chan *shared.DoormanUpdater, 10)
+	sub := &NSQSubscriber{NSQLookupURL: d.lookupd.URL, TrustPayloadTimestamp: true, Codec: codec.MsgPack}
+	if err := sub.Subscribe("foo", func(wu *shared.DoormanUpdater) error {
+		updaters <- wu
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Stop)
+	waitFor(t, d.subs)
+
+	p := &NSQPublisher{NSQDAddress: d.ln.Addr().String(), Codec: codec.MsgPack}
+	defer p.Stop()
+	if err := p.Publish(&shared.DoormanUpdater{Id: "foo", Timestamp: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if topic := waitFor(t, d.published); topic != "foo" {
+		t.Error("published on", topic)
+	}
+	if wu := waitFor(t, updaters); wu.Id != "foo" || wu.Timestamp != 3 || wu.Sequence != 1 {
+		t.Error("bad updater", wu)
+	}
+}
@@ -0,0 +1,60 @@
+package doorman
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+// RandomSource is the source of randomness of GetRandomCase.  Implementations
+// must be goroutine safe.
+type RandomSource interface {
+	Uint64() uint64
+	Float64() float64 // in [0, 1)
+}
+
+var defaultRandomSource = NewSeededRandomSource(time.Now().UnixNano())
+
+type seededRandomSource struct {
+	mu sync.Mutex
+	r  *mathrand.Rand
+}
+
+// NewSeededRandomSource returns a goroutine safe pseudo random source.  Two
+// sources with the same seed produce the same sequence, which makes
+// GetRandomCase deterministic in tests.
+func NewSeededRandomSource(seed int64) RandomSource {
+	return &seededRandomSource{r: mathrand.New(mathrand.NewSource(seed))}
+}
+
+func (s *seededRandomSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Uint64()
+}
+
+func (s *seededRandomSource) Float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Float64()
+}
+
+type cryptoRandomSource struct{}
+
+// CryptoRandomSource reads crypto/rand.  It is unpredictable but slower than
+// a seeded source.
+var CryptoRandomSource RandomSource = cryptoRandomSource{}
+
+func (cryptoRandomSource) Uint64() uint64 {
+	var b [8]byte
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (s cryptoRandomSource) Float64() float64 {
+	return float64(s.Uint64()>>11) / (1 << 53)
+}
@@ -0,0 +1,57 @@
+package doorman
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSeededRandomSource(t *testing.T) {
+	w1 := newDoorman(getProbs("1/4", "1/2", "1/4"))
+	w1.Random = NewSeededRandomSource(42)
+	w2 := newDoorman(getProbs("1/4", "1/2", "1/4"))
+	w2.Random = NewSeededRandomSource(42)
+	for i := 0; i < 100; i++ {
+		if c1, c2 := w1.GetRandomCase(), w2.GetRandomCase(); c1 != c2 {
+			t.Fatal("same seeds should give the same cases", i, c1, c2)
+		}
+	}
+}
+
+func TestCryptoRandomSource(t *testing.T) {
+	n := 10000
+	wab := newDoorman(getProbs("1/2", "1/2"))
+	wab.Random = CryptoRandomSource
+	var sum = 0
+	for i := 0; i < n; i++ {
+		sum += int(wab.GetRandomCase())
+	}
+	if err := IsExtremeBinomialResult(sum, float64(n), 0.5); err != nil {
+		t.Error(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if f := CryptoRandomSource.Float64(); f < 0 || f >= 1 {
+			t.Fatal("float out of [0, 1)", f)
+		}
+	}
+}
+
+// run with -race
+func TestGetRandomCaseParallel(t *testing.T) {
+	for _, source := range []RandomSource{nil, NewSeededRandomSource(1), CryptoRandomSource} {
+		wab := newDoorman(getProbs("1/2", "1/2"))
+		wab.Random = source
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					if c := wab.GetRandomCase(); c > 1 {
+						t.Error("bad case", c)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,75 @@
+package redissubscriber
+
+import (
+	"context"
+	"sync"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisPublisher sets the key KeyPrefix+id to the updater of a doorman and,
+// unless the subscribers rely on KeyspaceNotifications, publishes it on the
+// channel of the same name.
+type RedisPublisher struct {
+	Addr                  string
+	DB                    int
+	Password              string      // optional
+	KeyPrefix             string      // optional, "doorman:" by default
+	KeyspaceNotifications bool        // when true, the key is only set
+	Codec                 codec.Codec // optional, JSON by default
+	mu                    sync.Mutex
+	client                *redis.Client
+	seq                   shared.Sequencer
+}
+
+func (p *RedisPublisher) codec() codec.Codec {
+	if p.Codec == nil {
+		return codec.JSON
+	}
+	return p.Codec
+}
+
+func (p *RedisPublisher) getClient() *redis.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		p.client = redis.NewClient(&redis.Options{Addr: p.Addr, DB: p.DB, Password: p.Password})
+	}
+	return p.client
+}
+
+// Publish stores wu numbered with the next sequence number of its doorman.
+func (p *RedisPublisher) Publish(wu *shared.DoormanUpdater) error {
+	return p.seq.Publish(wu, p.publish)
+}
+
+func (p *RedisPublisher) publish(wu *shared.DoormanUpdater) error {
+	data, err := p.codec().Marshal(wu)
+	if err != nil {
+		return err
+	}
+	k := key(p.KeyPrefix, wu.Id)
+	// set and publish atomically so a subscriber reading the key after a
+	// disconnection cannot miss the publication
+	_, err = p.getClient().TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.Set(context.Background(), k, data, 0)
+		if !p.KeyspaceNotifications {
+			pipe.Publish(context.Background(), k, data)
+		}
+		return nil
+	})
+	return err
+}
+
+func (p *RedisPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		return nil
+	}
+	err := p.client.Close()
+	p.client = nil
+	return err
+}
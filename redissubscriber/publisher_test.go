@@ -0,0 +1,37 @@
+package redissubscriber
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestRedisPublisher(t *testing.T) {
+	m := miniredis.RunT(t)
+	timestamps := subscribe(t, &RedisSubscriber{Addr: m.Addr(), Codec: codec.MsgPack}, "foo")
+	p := &RedisPublisher{Addr: m.Addr(), Codec: codec.MsgPack}
+	defer p.Close()
+	if err := p.Publish(&shared.DoormanUpdater{Id: "foo", Timestamp: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expectTimestamp(t, timestamps, 1)
+
+	// a late subscriber reads the key
+	expectTimestamp(t, subscribe(t, &RedisSubscriber{Addr: m.Addr(), Codec: codec.MsgPack}, "foo"), 1)
+}
+
+func TestRedisPublisherKeyspaceNotifications(t *testing.T) {
+	m := miniredis.RunT(t)
+	p := &RedisPublisher{Addr: m.Addr(), KeyPrefix: "flags/", KeyspaceNotifications: true}
+	defer p.Close()
+	for _, timestamp := range []int64{1, 2} {
+		if err := p.Publish(&shared.DoormanUpdater{Id: "foo", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, err := m.Get("flags/foo"); err != nil || value != encode(t, codec.JSON, &shared.DoormanUpdater{Id: "foo", Timestamp: 2, Sequence: 2}) {
+		t.Error("bad key", value, err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package redissubscriber reads the doorman updaters from a redis key per
+// doorman and receives their changes with pub/sub.
+package redissubscriber
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+	"github.com/redis/go-redis/v9"
+)
+
+const transport = "redis"
+
+const defaultKeyPrefix = "doorman:"
+
+// RedisSubscriber reads the updater of a doorman from the key KeyPrefix+id.
+// The publisher either publishes the updaters on the channel of the same name
+// or, with KeyspaceNotifications, only sets the key.  The key is read again
+// after a disconnection since publications are not buffered by redis.
+type RedisSubscriber struct {
+	Addr                  string
+	DB                    int
+	Password              string                 // optional
+	KeyPrefix             string                 // optional, "doorman:" by default
+	KeyspaceNotifications bool                   // when true, the key is read on its keyspace notifications
+	Codec                 codec.Codec            // optional, JSON by default
+	Backoff               time.Duration          // optional, the delay before receiving again after an error, a second by default
+	Instrumentation       shared.Instrumentation // optional
+	Logger                shared.Logger          // optional
+	Resync                shared.ResyncFunc      // optional, called on a gap in the sequence numbers
+	mu                    sync.Mutex
+	closers               []func() error // close the subscriptions
+}
+
+func (s *RedisSubscriber) Transport() string {
+	return transport
+}
+
+func (s *RedisSubscriber) instrumentation() shared.Instrumentation {
+	if s.Instrumentation == nil {
+		return shared.NopInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+func (s *RedisSubscriber) logger() shared.Logger {
+	if s.Logger == nil {
+		return shared.NopLogger{}
+	}
+	return s.Logger
+}
+
+func (s *RedisSubscriber) codec() codec.Codec {
+	if s.Codec == nil {
+		return codec.JSON
+	}
+	return s.Codec
+}
+
+func (s *RedisSubscriber) backoff() time.Duration {
+	if s.Backoff == 0 {
+		return time.Second
+	}
+	return s.Backoff
+}
+
+func key(prefix, doormanId string) string {
+	if prefix == "" {
+		return defaultKeyPrefix + doormanId
+	}
+	return prefix + doormanId
+}
+
+func (s *RedisSubscriber) key(doormanId string) string {
+	return key(s.KeyPrefix, doormanId)
+}
+
+func (s *RedisSubscriber) channel(doormanId string) string {
+	if s.KeyspaceNotifications {
+		return "__keyspace@" + strconv.Itoa(s.DB) + "__:" + s.key(doormanId)
+	}
+	return s.key(doormanId)
+}
+
+func (s *RedisSubscriber) Subscribe(doormanId string, update shared.UpdateHandlerFunc) error {
+	ctx := context.Background()
+	update = (&shared.GapDetector{Resync: s.Resync, Logger: s.Logger}).Wrap(update)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr, DB: s.DB, Password: s.Password})
+	// subscribe before reading the key so no change is missed in between
+	pubsub := client.Subscribe(ctx, s.channel(doormanId))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		client.Close()
+		return err
+	}
+	if err := s.read(ctx, client, doormanId, update); err != nil {
+		pubsub.Close()
+		client.Close()
+		return err
+	}
+	s.mu.Lock()
+	s.closers = append(s.closers, pubsub.Close, client.Close)
+	s.mu.Unlock()
+	go s.receive(ctx, client, pubsub, doormanId, update)
+	return nil
+}
+
+// Close ends every subscription.
+func (s *RedisSubscriber) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret error
+	for _, close := range s.closers {
+		if err := close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	s.closers = nil
+	return ret
+}
+
+// read updates the doorman from its key, if set.
+func (s *RedisSubscriber) read(ctx context.Context, client *redis.Client, doormanId string, update shared.UpdateHandlerFunc) error {
+	data, err := client.Get(ctx, s.key(doormanId)).Bytes()
+	if err == redis.Nil {
+		s.logger().Info("doorman key not set", "doorman_id", doormanId, "transport", transport, "key", s.key(doormanId))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.callUpdateHandlerFunction(update, data)
+}
+
+func (s *RedisSubscriber) callUpdateHandlerFunction(f shared.UpdateHandlerFunc, data []byte) error {
+	wu := &shared.DoormanUpdater{}
+	if err := s.codec().Unmarshal(data, wu); err != nil {
+		return err
+	}
+	return f(wu)
+}
+
+func (s *RedisSubscriber) receive(ctx context.Context, client *redis.Client, pubsub *redis.PubSub, doormanId string, update shared.UpdateHandlerFunc) {
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err == redis.ErrClosed {
+			return
+		} else if err != nil {
+			s.logger().Error("cannot receive", "doorman_id", doormanId, "transport", transport, "error", err)
+			s.instrumentation().SubscriberError(doormanId, transport, err)
+			s.reconnect(ctx, client, pubsub, doormanId, update)
+			continue
+		}
+		if s.KeyspaceNotifications {
+			err = s.read(ctx, client, doormanId, update)
+		} else {
+			err = s.callUpdateHandlerFunction(update, []byte(msg.Payload))
+		}
+		if err != nil {
+			s.logger().Error("cannot update doorman with received data", "doorman_id", doormanId, "transport", transport, "error", err)
+		}
+	}
+}
+
+// reconnect waits until the channel is subscribed again and reads the key
+// since the changes published while disconnected are lost.
+func (s *RedisSubscriber) reconnect(ctx context.Context, client *redis.Client, pubsub *redis.PubSub, doormanId string, update shared.UpdateHandlerFunc) {
+	for {
+		time.Sleep(s.backoff())
+		if err := pubsub.Ping(ctx); err == redis.ErrClosed {
+			return
+		} else if err != nil {
+			continue
+		}
+		if err := s.read(ctx, client, doormanId, update); err != nil {
+			s.logger().Error("cannot read doorman key", "doorman_id", doormanId, "transport", transport, "error", err)
+			continue
+		}
+		s.logger().Info("receiving again", "doorman_id", doormanId, "transport", transport)
+		s.instrumentation().SubscriberReconnected(doormanId, transport)
+		return
+	}
+}
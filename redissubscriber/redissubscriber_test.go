@@ -0,0 +1,137 @@
+package redissubscriber
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/didiercrunch/doorman/codec"
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func encode(t *testing.T, c codec.Codec, wu *shared.DoormanUpdater) string {
+	data, err := c.Marshal(wu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// subscribe returns the channel of the timestamps of the updates.
+func subscribe(t *testing.T, s *RedisSubscriber, doormanId string) <-chan int64 {
+	timestamps := make(chan int64, 10)
+	if err := s.Subscribe(doormanId, func(wu *shared.DoormanUpdater) error {
+		timestamps <- wu.Timestamp
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return timestamps
+}
+
+func expectTimestamp(t *testing.T, timestamps <-chan int64, expected int64) {
+	select {
+	case timestamp := <-timestamps:
+		if timestamp != expected {
+			t.Error("received timestamp", timestamp, "but expected", expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for timestamp", expected)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.Set("doorman:foo", encode(t, codec.JSON, &shared.DoormanUpdater{Id: "foo", Timestamp: 1}))
+	timestamps := subscribe(t, &RedisSubscriber{Addr: m.Addr()}, "foo")
+	expectTimestamp(t, timestamps, 1)
+
+	m.Publish("doorman:foo", encode(t, codec.JSON, &shared.DoormanUpdater{Id: "foo", Timestamp: 2}))
+	expectTimestamp(t, timestamps, 2)
+}
+
+func TestSubscribeKeyspaceNotifications(t *testing.T) {
+	m := miniredis.RunT(t)
+	s := &RedisSubscriber{Addr: m.Addr(), KeyPrefix: "flags/", KeyspaceNotifications: true, Codec: codec.MsgPack}
+	timestamps := subscribe(t, s, "foo")
+
+	m.Set("flags/foo", encode(t, codec.MsgPack, &shared.DoormanUpdater{Id: "foo", Timestamp: 3}))
+	m.Publish("__keyspace@0__:flags/foo", "set")
+	expectTimestamp(t, timestamps, 3)
+}
+
+func TestGapDetection(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.Set("doorman:foo", encode(t, codec.JSON, &shared.DoormanUpdater{Id: "foo", Timestamp: 1, Sequence: 1}))
+	resyncs := make(chan string, 10)
+	s := &RedisSubscriber{Addr: m.Addr(), Resync: func(doormanId string) (*shared.DoormanUpdater, error) {
+		resyncs <- doormanId
+		return &shared.DoormanUpdater{Id: doormanId, Timestamp: 100}, nil
+	}}
+	timestamps := subscribe(t, s, "foo")
+	expectTimestamp(t, timestamps, 1)
+
+	// 2 is missing, then delivered late
+	m.Publish("doorman:foo", encode(t, codec.JSON, &shared.DoormanUpdater{Id: "foo", Timestamp: 3, Sequence: 3}))
+	m.Publish("doorman:foo", encode(t, codec.JSON, &shared.DoormanUpdater{Id: "foo", Timestamp: 2, Sequence: 2}))
+	m.Publish("doorman:foo", encode(t, codec.JSON, &shared.DoormanUpdater{Id: "foo", Timestamp: 4, Sequence: 4}))
+	expectTimestamp(t, timestamps, 100)
+	expectTimestamp(t, timestamps, 3)
+	expectTimestamp(t, timestamps, 4)
+	if doormanId := <-resyncs; doormanId != "foo" {
+		t.Error("resynchronized the wrong doorman", doormanId)
+	}
+	if len(resyncs) != 0 {
+		t.Error("resynchronized too often")
+	}
+}
+
+type recordingInstrumentation struct {
+	shared.NopInstrumentation
+	mu         sync.Mutex
+	errors     int
+	reconnects int
+}
+
+func (i *recordingInstrumentation) SubscriberError(doormanId, transport string, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.errors++
+}
+
+func (i *recordingInstrumentation) SubscriberReconnected(doormanId, transport string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.reconnects++
+}
+
+func TestReconnect(t *testing.T) {
+	m := miniredis.RunT(t)
+	i := new(recordingInstrumentation)
+	timestamps := subscribe(t, &RedisSubscriber{Addr: m.Addr(), Backoff: 10 * time.Millisecond, Instrumentation: i}, "foo")
+
+	m.Close()
+	time.Sleep(50 * time.Millisecond)
+	// changed while disconnected, without publication
+	m.Set("doorman:foo", encode(t, codec.JSON, &shared.DoormanUpdater{Id: "foo", Timestamp: 4}))
+	if err := m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	expectTimestamp(t, timestamps, 4)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.errors != 1 || i.reconnects != 1 {
+		t.Error("bad instrumentation", i.errors, i.reconnects)
+	}
+}
+
+func TestSubscribeError(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.Set("doorman:foo", "not json")
+	if err := (&RedisSubscriber{Addr: m.Addr()}).Subscribe("foo", func(wu *shared.DoormanUpdater) error { return nil }); err == nil {
+		t.Error("expected an error for a bad key")
+	}
+}
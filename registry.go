@@ -0,0 +1,161 @@
+package doorman
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// Registry holds doormen whose prerequisites reference each other.  A doorman
+// of a registry resolves to its control case 0 unless, for the same key, each
+// of its prerequisite doormen resolves to one of the prerequisite cases.  A
+// prerequisite on a doorman absent from the registry is never met.  Updates
+// creating a cycle of prerequisites are rejected.
+type Registry struct {
+	mu      sync.RWMutex
+	doormen map[string]*Doorman
+	version int64 // the version of the last applied snapshot
+}
+
+func NewRegistry() *Registry {
+	return &Registry{doormen: make(map[string]*Doorman)}
+}
+
+// Add adds w to the registry.  A doorman belongs to at most one registry.
+func (r *Registry) Add(w *Doorman) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.registry != nil && w.registry != r {
+		return errors.New("doorman already in another registry")
+	}
+	if _, ok := r.doormen[w.Id]; ok && w.registry != r {
+		return errors.New("doorman id already in the registry")
+	}
+	if r.hasCycle(w.Id, w.prerequisites, nil) {
+		return ErrPrerequisiteCycle
+	}
+	r.doormen[w.Id] = w
+	w.registry = r
+	return nil
+}
+
+func (r *Registry) Get(doormanId string) (*Doorman, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	w, ok := r.doormen[doormanId]
+	return w, ok
+}
+
+// Update hands wu to the doorman of the registry with its id, for the
+// subscribers streaming many doormen.  Updaters of other doormen are ignored.
+func (r *Registry) Update(wu *shared.DoormanUpdater) error {
+	w, ok := r.Get(wu.Id)
+	if !ok {
+		return nil
+	}
+	return w.Update(wu)
+}
+
+func (r *Registry) GetCaseFromData(doormanId string, data ...[]byte) (uint, error) {
+	w, ok := r.Get(doormanId)
+	if !ok {
+		return 0, errors.New("unknown doorman " + doormanId)
+	}
+	return w.GetCaseFromData(data...), nil
+}
+
+func (r *Registry) GetCaseFromString(doormanId string, data string) (uint, error) {
+	return r.GetCaseFromData(doormanId, []byte(data))
+}
+
+func (w *Doorman) getRegistry() *Registry {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.registry
+}
+
+func (w *Doorman) getPrerequisites() []*shared.Prerequisite {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.prerequisites
+}
+
+// hasCycle returns true if giving prerequisites to doorman id would create a
+// cycle.  The prerequisites of the doormen of pending override their current
+// ones.  The caller holds r.mu, the lock of the doorman id if it is in the
+// registry and the locks of the doormen of pending.
+func (r *Registry) hasCycle(id string, prerequisites []*shared.Prerequisite, pending map[string][]*shared.Prerequisite) bool {
+	visited := make(map[string]bool)
+	var reaches func(from string) bool
+	reaches = func(from string) bool {
+		if from == id {
+			return true
+		}
+		if visited[from] {
+			return false
+		}
+		visited[from] = true
+		next, ok := pending[from]
+		if !ok {
+			w, ok := r.doormen[from]
+			if !ok {
+				return false
+			}
+			next = w.getPrerequisites()
+		}
+		for _, p := range next {
+			if reaches(p.DoormanId) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, p := range prerequisites {
+		if reaches(p.DoormanId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Registry) prerequisitesMet(w *Doorman, data [][]byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.met(w, data)
+}
+
+// met evaluates the prerequisites of w recursively, which terminates since
+// they have no cycle.  The caller holds r.mu for reading.
+func (r *Registry) met(w *Doorman, data [][]byte) bool {
+	for _, p := range w.getPrerequisites() {
+		d, ok := r.doormen[p.DoormanId]
+		if !ok || !containsCase(p.Cases, r.resolve(d, data)) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve returns the case of data like w.GetCaseFromData without reporting
+// an evaluation.  The caller holds r.mu for reading.
+func (r *Registry) resolve(w *Doorman, data [][]byte) uint {
+	if c, killed := w.ForcedCase(); killed {
+		return c
+	}
+	if !r.met(w, data) {
+		return 0
+	}
+	return w.assign(data)
+}
+
+func containsCase(cases []uint, c uint) bool {
+	for _, candidate := range cases {
+		if candidate == c {
+			return true
+		}
+	}
+	return false
+}
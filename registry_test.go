@@ -0,0 +1,121 @@
+package doorman
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func newRegisteredDoorman(t *testing.T, r *Registry, n byte, probs ...string) *Doorman {
+	id := make([]byte, 16)
+	id[0] = n
+	w, err := New(base64.URLEncoding.EncodeToString(id), getProbs(probs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(w); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func setPrerequisites(w *Doorman, timestamp int64, prerequisites ...*shared.Prerequisite) error {
+	return w.Update(&shared.DoormanUpdater{
+		Id:            w.Id,
+		Timestamp:     timestamp,
+		Probabilities: w.Probabilities,
+		Prerequisites: prerequisites,
+	})
+}
+
+func TestPrerequisites(t *testing.T) {
+	r := NewRegistry()
+	parent := newRegisteredDoorman(t, r, 1, "1/2", "1/2")
+	child := newRegisteredDoorman(t, r, 2, "0", "1")
+	if err := setPrerequisites(child, 1, &shared.Prerequisite{DoormanId: parent.Id, Cases: []uint{1}}); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[uint]int)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprint("user-", i)
+		p := parent.GetCaseFromString(key)
+		c, err := r.GetCaseFromString(child.Id, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != p {
+			t.Fatal("child resolved to", c, "while parent resolved to", p)
+		}
+		seen[p]++
+	}
+	if seen[0] == 0 || seen[1] == 0 {
+		t.Error("expected both parent cases", seen)
+	}
+
+	parent.Kill(1)
+	if c := child.GetCaseFromString("foo"); c != 1 {
+		t.Error("a parent forced on the prerequisite case should meet it", c)
+	}
+	parent.Kill(0)
+	if c := child.GetCaseFromString("foo"); c != 0 {
+		t.Error("a parent forced off the prerequisite case should not meet it", c)
+	}
+}
+
+func TestPrerequisiteUnknownDoorman(t *testing.T) {
+	r := NewRegistry()
+	w := newRegisteredDoorman(t, r, 1, "0", "1")
+	if err := setPrerequisites(w, 1, &shared.Prerequisite{DoormanId: oid, Cases: []uint{0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if c := w.GetCaseFromString("foo"); c != 0 {
+		t.Error("a prerequisite on an unknown doorman should not be met", c)
+	}
+	if _, err := r.GetCaseFromString(oid, "foo"); err == nil {
+		t.Error("expected an error for an unknown doorman")
+	}
+}
+
+func TestPrerequisiteCycle(t *testing.T) {
+	r := NewRegistry()
+	a := newRegisteredDoorman(t, r, 1, "1/2", "1/2")
+	b := newRegisteredDoorman(t, r, 2, "1/2", "1/2")
+	if err := setPrerequisites(a, 1, &shared.Prerequisite{DoormanId: b.Id, Cases: []uint{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setPrerequisites(b, 1, &shared.Prerequisite{DoormanId: a.Id, Cases: []uint{1}}); err != ErrPrerequisiteCycle {
+		t.Error("expected a cycle error", err)
+	}
+	if err := setPrerequisites(a, 2, &shared.Prerequisite{DoormanId: a.Id, Cases: []uint{1}}); err != ErrPrerequisiteCycle {
+		t.Error("expected a cycle error", err)
+	}
+	if len(b.getPrerequisites()) != 0 || a.LastChangeTimestamp != 1 {
+		t.Error("rejected updates should not be applied")
+	}
+
+	if err := NewRegistry().Add(b); err == nil {
+		t.Error("a doorman should belong to a single registry")
+	}
+
+	c, _ := New(base64.URLEncoding.EncodeToString(make([]byte, 16)), getProbs("1/2", "1/2"))
+	if err := setPrerequisites(c, 1, &shared.Prerequisite{DoormanId: c.Id, Cases: []uint{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(c); err != ErrPrerequisiteCycle {
+		t.Error("expected a cycle error", err)
+	}
+}
+
+func TestRegistryUpdate(t *testing.T) {
+	r := NewRegistry()
+	w := newRegisteredDoorman(t, r, 1, "1/2", "1/2")
+	if err := r.Update(&shared.DoormanUpdater{Id: w.Id, Timestamp: 1, Probabilities: getProbs("1/4", "3/4")}); err != nil {
+		t.Fatal(err)
+	}
+	assertIsEqual(t, getProbs("1/4")[0], w.Probabilities[0])
+	if err := r.Update(&shared.DoormanUpdater{Id: oid, Timestamp: 1, Probabilities: getProbs("1/4")}); err != nil {
+		t.Error("updaters of unknown doormen should be ignored", err)
+	}
+}
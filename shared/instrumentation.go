@@ -0,0 +1,39 @@
+package shared
+
+// The reasons given to Instrumentation.UpdateRejected.
+const (
+	RejectedStaleTimestamp = "stale_timestamp"
+	RejectedBadId          = "bad_id"
+	RejectedBadSum         = "bad_sum"
+	RejectedBadForcedCase  = "bad_forced_case"
+	RejectedBadAlgorithm   = "bad_algorithm"
+	RejectedCycle          = "prerequisite_cycle"
+	RejectedOther          = "other"
+)
+
+// Instrumentation receives the events of a doorman client and of its
+// subscribers.  Implementations must be goroutine safe.
+type Instrumentation interface {
+	Evaluated(doormanId string, c uint)
+	UpdateAccepted(doormanId string)
+	UpdateRejected(doormanId, reason string)
+	SubscriberReconnected(doormanId, transport string)
+	SubscriberError(doormanId, transport string, err error)
+}
+
+// KeyedInstrumentation is the Instrumentation also receiving the key of the
+// evaluations of GetCaseFromData and its variants, the key being the
+// concatenation of the data.  EvaluatedKey replaces Evaluated for them.
+type KeyedInstrumentation interface {
+	Instrumentation
+	EvaluatedKey(doormanId, key string, c uint)
+}
+
+// NopInstrumentation ignores every event.  It is the default instrumentation.
+type NopInstrumentation struct{}
+
+func (NopInstrumentation) Evaluated(doormanId string, c uint)                     {}
+func (NopInstrumentation) UpdateAccepted(doormanId string)                        {}
+func (NopInstrumentation) UpdateRejected(doormanId, reason string)                {}
+func (NopInstrumentation) SubscriberReconnected(doormanId, transport string)      {}
+func (NopInstrumentation) SubscriberError(doormanId, transport string, err error) {}
@@ -6,6 +6,35 @@ type DoormanUpdater struct {
 	Id            string     `json:"id"`
 	Timestamp     int64      `json:"timestamp"`
 	Probabilities []*big.Rat `json:"probabilities"`
+	Killed        bool       `json:"killed,omitempty"`      // when true, every case evaluates to ForcedCase
+	ForcedCase    uint       `json:"forced_case,omitempty"` // the case forced by a killed doorman, the control case by default
+	Reset         bool       `json:"reset,omitempty"`       // when true, the sticky assignments of the doorman are forgotten
+	Algorithm     int        `json:"algorithm,omitempty"`   // the bucketing algorithm, the legacy algorithm 1 when absent
+	Sequence      uint64     `json:"sequence,omitempty"`    // the position of the update in its stream, starting at 1
+	// the doorman resolves to its control case 0 unless every prerequisite is met
+	Prerequisites []*Prerequisite `json:"prerequisites,omitempty"`
+}
+
+// Prerequisite is met when the doorman DoormanId resolves to one of Cases for
+// the same key.
+type Prerequisite struct {
+	DoormanId string `json:"doorman_id"`
+	Cases     []uint `json:"cases"`
 }
 
 type UpdateHandlerFunc func(m *DoormanUpdater) error
+
+// Snapshot holds the updaters of many doormen.  Version increases with every
+// change of any of them.
+type Snapshot struct {
+	Version int64             `json:"version"`
+	Doormen []*DoormanUpdater `json:"doormen"`
+}
+
+type SnapshotHandlerFunc func(s *Snapshot) error
+
+// Publisher sends the updaters of doormen to their subscribers, encoded and
+// framed the way the subscriber of the same transport expects.
+type Publisher interface {
+	Publish(wu *DoormanUpdater) error
+}
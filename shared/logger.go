@@ -0,0 +1,27 @@
+package shared
+
+import "log/slog"
+
+// Logger receives the log messages of a doorman client and of its subscribers.
+// The args are alternating keys and values, as in log/slog, with the keys
+// "doorman_id", "timestamp", "transport" and "error".
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger discards every message.  It is the default logger.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+func (NopLogger) Info(msg string, args ...interface{})  {}
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// SlogLogger adapts l, or slog.Default() if l is nil, to a Logger.
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l
+}
@@ -0,0 +1,28 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := SlogLogger(slog.New(slog.NewJSONHandler(buf, nil)))
+	l.Info("doorman updated", "doorman_id", "foo", "timestamp", 10)
+
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["msg"] != "doorman updated" || m["doorman_id"] != "foo" || m["timestamp"] != 10.0 {
+		t.Error("bad log record", m)
+	}
+}
+
+func TestSlogLoggerDefault(t *testing.T) {
+	if SlogLogger(nil) != Logger(slog.Default()) {
+		t.Error("expected the default slog logger")
+	}
+}
@@ -0,0 +1,85 @@
+package shared
+
+import "sync"
+
+// ResyncFunc returns the current updater of the doorman doormanId, typically
+// from the http status endpoint of the server.
+type ResyncFunc func(doormanId string) (*DoormanUpdater, error)
+
+// GapDetector checks the sequence numbers of the updates of a stream.  When
+// some updates are missing, the doorman is resynchronized with Resync before
+// the update is applied; updates older than the last one are dropped.  A
+// stream restarting at sequence 1 is resynchronized too.  Updates without
+// sequence number are applied as is.
+type GapDetector struct {
+	Resync ResyncFunc // optional, gaps are only logged when nil
+	Logger Logger     // optional
+	mu     sync.Mutex
+	last   uint64 // the sequence number of the last update, 0 before the first one
+}
+
+func (g *GapDetector) logger() Logger {
+	if g.Logger == nil {
+		return NopLogger{}
+	}
+	return g.Logger
+}
+
+// Wrap returns update checking the sequence numbers of the updates.
+func (g *GapDetector) Wrap(update UpdateHandlerFunc) UpdateHandlerFunc {
+	return func(wu *DoormanUpdater) error {
+		if wu.Sequence == 0 {
+			return update(wu)
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		switch {
+		case g.last == 0 || wu.Sequence == g.last+1:
+		case wu.Sequence <= g.last && wu.Sequence != 1:
+			g.logger().Info("out of order update dropped", "doorman_id", wu.Id, "sequence", wu.Sequence, "last_sequence", g.last)
+			return nil
+		default:
+			g.logger().Error("gap in the update sequence", "doorman_id", wu.Id, "sequence", wu.Sequence, "last_sequence", g.last)
+			if err := g.resync(wu.Id, update); err != nil {
+				// the gap stays open so the next update resynchronizes again
+				update(wu)
+				return err
+			}
+		}
+		g.last = wu.Sequence
+		return update(wu)
+	}
+}
+
+func (g *GapDetector) resync(doormanId string, update UpdateHandlerFunc) error {
+	if g.Resync == nil {
+		return nil
+	}
+	wu, err := g.Resync(doormanId)
+	if err != nil {
+		return err
+	}
+	return update(wu)
+}
+
+// Sequencer numbers the updaters of every doorman from 1, in the order of
+// their publication, for the GapDetector of the subscribers.
+type Sequencer struct {
+	mu   sync.Mutex
+	last map[string]uint64
+}
+
+// Publish sends a copy of wu numbered with the next sequence number of its
+// doorman.  The updaters are sent one at a time so they leave in order; a
+// failed send still uses its number, the subscribers resync on the gap.
+func (s *Sequencer) Publish(wu *DoormanUpdater, send func(*DoormanUpdater) error) error {
+	numbered := *wu
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		s.last = make(map[string]uint64)
+	}
+	s.last[wu.Id]++
+	numbered.Sequence = s.last[wu.Id]
+	return send(&numbered)
+}
@@ -0,0 +1,86 @@
+package shared
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// applySequences feeds updates with the given sequence numbers to a gap
+// detector and returns the sequence numbers applied, 0 for a resync.
+func applySequences(g *GapDetector, sequences ...uint64) []uint64 {
+	var applied []uint64
+	update := g.Wrap(func(wu *DoormanUpdater) error {
+		applied = append(applied, wu.Sequence)
+		return nil
+	})
+	for _, s := range sequences {
+		update(&DoormanUpdater{Id: "foo", Sequence: s})
+	}
+	return applied
+}
+
+func TestGapDetector(t *testing.T) {
+	resync := func(doormanId string) (*DoormanUpdater, error) {
+		if doormanId != "foo" {
+			t.Error("resynchronized the wrong doorman", doormanId)
+		}
+		return &DoormanUpdater{Id: "foo"}, nil
+	}
+	tests := []struct {
+		name      string
+		sequences []uint64
+		applied   []uint64
+	}{
+		{"in order", []uint64{5, 6, 7}, []uint64{5, 6, 7}},
+		{"dropped", []uint64{1, 2, 4, 5}, []uint64{1, 2, 0, 4, 5}},
+		{"reordered", []uint64{1, 3, 2, 4}, []uint64{1, 0, 3, 4}},
+		{"duplicated", []uint64{1, 2, 2, 3}, []uint64{1, 2, 3}},
+		{"restarted", []uint64{7, 8, 1, 2}, []uint64{7, 8, 0, 1, 2}},
+		{"unsequenced", []uint64{1, 0, 0, 2}, []uint64{1, 0, 0, 2}},
+	}
+	for _, test := range tests {
+		if applied := applySequences(&GapDetector{Resync: resync}, test.sequences...); !reflect.DeepEqual(applied, test.applied) {
+			t.Error(test.name, "applied", applied, "but expected", test.applied)
+		}
+	}
+}
+
+func TestGapDetectorFailingResync(t *testing.T) {
+	fail := true
+	resync := func(doormanId string) (*DoormanUpdater, error) {
+		if fail {
+			return nil, errors.New("unreachable")
+		}
+		return &DoormanUpdater{Id: "foo"}, nil
+	}
+	g := &GapDetector{Resync: resync}
+	if applied := applySequences(g, 1, 3); !reflect.DeepEqual(applied, []uint64{1, 3}) {
+		t.Error("bad applied updates", applied)
+	}
+	fail = false
+	if applied := applySequences(g, 4, 5); !reflect.DeepEqual(applied, []uint64{0, 4, 5}) {
+		t.Error("the gap should be resynchronized by the next update", applied)
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	var s Sequencer
+	var sent []uint64
+	send := func(wu *DoormanUpdater) error {
+		sent = append(sent, wu.Sequence)
+		return errors.New("unreachable")
+	}
+	for _, id := range []string{"foo", "bar", "foo", "foo"} {
+		if err := s.Publish(&DoormanUpdater{Id: id}, send); err == nil {
+			t.Error("the error of send should be returned")
+		}
+	}
+	if !reflect.DeepEqual(sent, []uint64{1, 1, 2, 3}) {
+		t.Error("every doorman should be numbered from 1", sent)
+	}
+	wu := &DoormanUpdater{Id: "foo"}
+	if s.Publish(wu, send); wu.Sequence != 0 {
+		t.Error("the updater published should not be modified")
+	}
+}
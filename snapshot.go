@@ -0,0 +1,113 @@
+package doorman
+
+import (
+	"errors"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+var ErrDuplicateDoorman = errors.New("doorman present twice in the snapshot")
+
+// SnapshotSubscriber feeds the snapshots of every doorman to a registry from
+// a single connection.
+type SnapshotSubscriber interface {
+	SubscribeSnapshots(update shared.SnapshotHandlerFunc) error
+}
+
+func (r *Registry) SubscribeSnapshots(sub SnapshotSubscriber) error {
+	return sub.SubscribeSnapshots(r.ApplySnapshot)
+}
+
+// Version returns the version of the last applied snapshot.
+func (r *Registry) Version() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.version
+}
+
+type change struct {
+	w         *Doorman
+	wu        *shared.DoormanUpdater
+	algorithm int
+	err       error
+}
+
+// ApplySnapshot updates the doormen of the registry transactionally: either
+// every updater of s is applied or none is, and no evaluation sees a partially
+// applied snapshot.  Updaters of doormen absent from the registry and stale
+// updaters are ignored, so are snapshots not newer than the last applied one,
+// although the doormen record them as seen.
+func (r *Registry) ApplySnapshot(s *shared.Snapshot) error {
+	changes, err := r.applySnapshot(s)
+	for _, c := range changes {
+		c.w.recordUpdate(c.wu, c.err)
+		if c.err == nil {
+			c.w.instrumentation().UpdateAccepted(c.w.Id)
+		} else {
+			c.w.instrumentation().UpdateRejected(c.w.Id, rejectionReason(c.err))
+		}
+	}
+	return err
+}
+
+func (r *Registry) applySnapshot(s *shared.Snapshot) ([]*change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// like stale updates, the updaters of a stale snapshot still prove the
+	// server is reachable
+	var stale []*change
+	if s.Version <= r.version {
+		for _, wu := range s.Doormen {
+			if w, ok := r.doormen[wu.Id]; ok {
+				stale = append(stale, &change{w: w, wu: wu, err: errStaleTimestamp})
+			}
+		}
+		return stale, nil
+	}
+
+	// the doormen are locked together so evaluations wait for the whole
+	// snapshot; this cannot deadlock since r.mu is always locked first.
+	var changes []*change
+	pending := make(map[string][]*shared.Prerequisite)
+	defer func() {
+		for id := range pending {
+			r.doormen[id].mu.Unlock()
+		}
+	}()
+	for _, wu := range s.Doormen {
+		w, ok := r.doormen[wu.Id]
+		if !ok {
+			continue
+		}
+		if _, ok := pending[wu.Id]; ok {
+			return stale, ErrDuplicateDoorman
+		}
+		w.mu.Lock()
+		pending[wu.Id] = w.prerequisites
+		if wu.Timestamp <= w.LastChangeTimestamp {
+			stale = append(stale, &change{w: w, wu: wu, err: errStaleTimestamp})
+			continue
+		}
+		algorithm, err := w.check(wu)
+		if err != nil {
+			return append(stale, &change{w: w, wu: wu, err: err}), err
+		}
+		pending[wu.Id] = wu.Prerequisites
+		changes = append(changes, &change{w: w, wu: wu, algorithm: algorithm})
+	}
+	for _, c := range changes {
+		if r.hasCycle(c.w.Id, c.wu.Prerequisites, pending) {
+			return append(stale, &change{w: c.w, wu: c.wu, err: ErrPrerequisiteCycle}), ErrPrerequisiteCycle
+		}
+	}
+
+	var err error
+	for _, c := range changes {
+		// only resetting the assignments can fail here, too late to roll back
+		if c.err = c.w.apply(c.wu, c.algorithm); c.err != nil && err == nil {
+			err = c.err
+		}
+	}
+	r.version = s.Version
+	return append(changes, stale...), err
+}
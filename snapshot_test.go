@@ -0,0 +1,92 @@
+package doorman
+
+import (
+	"testing"
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+func TestApplySnapshot(t *testing.T) {
+	r := NewRegistry()
+	a := newRegisteredDoorman(t, r, 1, "1/2", "1/2")
+	b := newRegisteredDoorman(t, r, 2, "1/2", "1/2")
+
+	bad := &shared.Snapshot{Version: 1, Doormen: []*shared.DoormanUpdater{
+		{Id: a.Id, Timestamp: 1, Probabilities: getProbs("1/4", "3/4")},
+		{Id: b.Id, Timestamp: 1, Probabilities: getProbs("1/4", "1/4")},
+	}}
+	if err := r.ApplySnapshot(bad); err != ErrBadSum {
+		t.Error("expected a bad sum", err)
+	}
+	if a.LastChangeTimestamp != 0 || r.Version() != 0 {
+		t.Error("a rejected snapshot should not be applied")
+	}
+	assertIsEqual(t, getProbs("1/2")[0], a.Probabilities[0])
+
+	good := &shared.Snapshot{Version: 2, Doormen: []*shared.DoormanUpdater{
+		{Id: a.Id, Timestamp: 1, Probabilities: getProbs("1/4", "3/4")},
+		{Id: b.Id, Timestamp: 1, Killed: true, ForcedCase: 1},
+		{Id: oid, Timestamp: 1, Probabilities: getProbs("1")},
+	}}
+	if err := r.ApplySnapshot(good); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version() != 2 || a.LastChangeTimestamp != 1 {
+		t.Error("the snapshot should be applied")
+	}
+	assertIsEqual(t, getProbs("1/4")[0], a.Probabilities[0])
+	if c, killed := b.ForcedCase(); !killed || c != 1 {
+		t.Error("b should be killed", c, killed)
+	}
+
+	b.statusMu.Lock()
+	b.lastSeen = time.Time{}
+	b.statusMu.Unlock()
+	stale := &shared.Snapshot{Version: 2, Doormen: []*shared.DoormanUpdater{
+		{Id: a.Id, Timestamp: 2, Probabilities: getProbs("1")},
+		{Id: b.Id, Timestamp: 1, Killed: true, ForcedCase: 1},
+	}}
+	if err := r.ApplySnapshot(stale); err != nil || a.LastChangeTimestamp != 1 {
+		t.Error("a stale snapshot should be ignored", err)
+	}
+	if b.Status().LastSeen.IsZero() {
+		t.Error("the updaters of a stale snapshot should be seen")
+	}
+
+	// a newer snapshot with an unchanged doorman
+	b.statusMu.Lock()
+	b.lastSeen = time.Time{}
+	b.statusMu.Unlock()
+	unchanged := &shared.Snapshot{Version: 3, Doormen: []*shared.DoormanUpdater{
+		{Id: a.Id, Timestamp: 2, Probabilities: getProbs("1/2", "1/2")},
+		{Id: b.Id, Timestamp: 1, Killed: true, ForcedCase: 1},
+	}}
+	if err := r.ApplySnapshot(unchanged); err != nil || a.LastChangeTimestamp != 2 {
+		t.Error("the snapshot should be applied", err)
+	}
+	if b.Status().LastSeen.IsZero() {
+		t.Error("a stale updater should be seen")
+	}
+}
+
+func TestApplySnapshotCycle(t *testing.T) {
+	r := NewRegistry()
+	a := newRegisteredDoorman(t, r, 1, "1/2", "1/2")
+	b := newRegisteredDoorman(t, r, 2, "1/2", "1/2")
+	snapshot := &shared.Snapshot{Version: 1, Doormen: []*shared.DoormanUpdater{
+		{Id: a.Id, Timestamp: 1, Probabilities: getProbs("1/2", "1/2"), Prerequisites: []*shared.Prerequisite{{DoormanId: b.Id}}},
+		{Id: b.Id, Timestamp: 1, Probabilities: getProbs("1/2", "1/2"), Prerequisites: []*shared.Prerequisite{{DoormanId: a.Id}}},
+	}}
+	if err := r.ApplySnapshot(snapshot); err != ErrPrerequisiteCycle {
+		t.Error("expected a cycle", err)
+	}
+
+	snapshot = &shared.Snapshot{Version: 1, Doormen: []*shared.DoormanUpdater{
+		{Id: a.Id, Timestamp: 1, Probabilities: getProbs("1/2", "1/2")},
+		{Id: a.Id, Timestamp: 2, Probabilities: getProbs("1/2", "1/2")},
+	}}
+	if err := r.ApplySnapshot(snapshot); err != ErrDuplicateDoorman {
+		t.Error("expected a duplicate", err)
+	}
+}
@@ -0,0 +1,86 @@
+package doorman
+
+import (
+	"time"
+
+	"github.com/didiercrunch/doorman/shared"
+)
+
+// Status is a snapshot of the state of a doorman as seen by the client.
+type Status struct {
+	Id                  string    `json:"id"`
+	Valid               bool      `json:"valid"`       // true when the probabilities are valid
+	Initialized         bool      `json:"initialized"` // true once an update has been received from the server
+	Killed              bool      `json:"killed"`
+	Algorithm           int       `json:"algorithm"`
+	LastChangeTimestamp int64     `json:"last_change_timestamp"`
+	LastSeen            time.Time `json:"last_seen"` // the last time an update has been received from the server
+	Transport           string    `json:"transport,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+func (w *Doorman) Status() *Status {
+	valid := w.Validate() == nil
+	w.mu.RLock()
+	_, killed := w.forced()
+	s := &Status{
+		Id:                  w.Id,
+		Valid:               valid,
+		Killed:              killed,
+		Algorithm:           w.Algorithm,
+		LastChangeTimestamp: w.LastChangeTimestamp,
+	}
+	w.mu.RUnlock()
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	s.Initialized = !w.lastSeen.IsZero()
+	s.LastSeen = w.lastSeen
+	s.Transport = w.transport
+	if w.lastError != nil {
+		s.LastError = w.lastError.Error()
+	}
+	return s
+}
+
+// recordUpdate keeps track of the updates addressed to the doorman.  A stale
+// update still proves the server is reachable.
+func (w *Doorman) recordUpdate(wu *shared.DoormanUpdater, err error) {
+	if wu.Id != w.Id {
+		return
+	}
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	switch err {
+	case nil:
+		w.lastSeen = time.Now()
+		w.lastError = nil
+	case errStaleTimestamp:
+		w.lastSeen = time.Now()
+	default:
+		w.lastError = err
+	}
+}
+
+func (w *Doorman) recordError(err error) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.lastError = err
+}
+
+func (w *Doorman) recordTransport(transport string) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.transport = transport
+}
+
+// statusInstrumentation records the subscriber errors in the status of the
+// doorman before handing them to its instrumentation.
+type statusInstrumentation struct {
+	shared.Instrumentation
+	w *Doorman
+}
+
+func (i *statusInstrumentation) SubscriberError(doormanId, transport string, err error) {
+	i.w.recordError(err)
+	i.Instrumentation.SubscriberError(doormanId, transport, err)
+}
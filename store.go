@@ -0,0 +1,13 @@
+package doorman
+
+// AssignmentStore remembers the case assigned to a key so that a key keeps its
+// case when the probabilities of the doorman are updated.  Implementations live
+// in the assignmentstore package.
+type AssignmentStore interface {
+	// Get returns the case assigned to key and whether there is one.
+	Get(doormanId, key string) (uint, bool, error)
+	// Set assigns case c to key.
+	Set(doormanId, key string, c uint) error
+	// Reset forgets every assignment of the doorman.
+	Reset(doormanId string) error
+}
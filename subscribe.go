@@ -1,7 +1,10 @@
 package doorman
 
 import (
+	"github.com/didiercrunch/doorman/httpsubscriber"
+	"github.com/didiercrunch/doorman/kafkasubscriber"
 	"github.com/didiercrunch/doorman/nanomsgsubscriber"
+	"github.com/didiercrunch/doorman/natssubscriber"
 	"github.com/didiercrunch/doorman/nsqsubscriber"
 	"github.com/didiercrunch/doorman/shared"
 	"github.com/didiercrunch/doorman/subscriber"
@@ -11,21 +14,68 @@ type Subscriber interface {
 	Subscribe(doormanId string, update shared.UpdateHandlerFunc) error
 }
 
-func (w *Doorman) subscribe(sub Subscriber) error {
-	return sub.Subscribe(w.Id, w.Update)
+// Subscribe hands the Update method of the doorman to sub, which calls it
+// whenever the doorman changes.
+func (w *Doorman) Subscribe(sub Subscriber) error {
+	err := sub.Subscribe(w.Id, w.Update)
+	if t, ok := sub.(interface {
+		Transport() string
+	}); ok {
+		w.recordTransport(t.Transport())
+	}
+	if err != nil {
+		w.recordError(err)
+	}
+	return err
+}
+
+func (w *Doorman) subscriberInstrumentation() shared.Instrumentation {
+	return &statusInstrumentation{w.instrumentation(), w}
+}
+
+// resync returns the ResyncFunc of the push transports, nil without server.
+func resync(serverUrl string) shared.ResyncFunc {
+	if serverUrl == "" {
+		return nil
+	}
+	return httpsubscriber.Resync(serverUrl)
 }
 
 func (w *Doorman) NSQSubscriber(NSQLookupdURl string) error {
-	sub := &nsqsubscriber.NSQSubscriber{NSQLookupdURl}
-	return w.subscribe(sub)
+	return w.NSQSubscriberWithResync(NSQLookupdURl, "")
+}
+
+// NSQSubscriberWithResync subscribes the doorman to NSQ.  On a gap in the
+// updates, the doorman is resynchronized from the status endpoint of the
+// server serverUrl.
+func (w *Doorman) NSQSubscriberWithResync(NSQLookupdURl, serverUrl string) error {
+	sub := &nsqsubscriber.NSQSubscriber{NSQLookupURL: NSQLookupdURl, Instrumentation: w.subscriberInstrumentation(), Logger: w.Logger, Resync: resync(serverUrl)}
+	return w.Subscribe(sub)
 }
 
 func (w *Doorman) NanoMsgSubscriber(NanoMsgUrlLookupdURl string) error {
-	sub := &nanomsgsubscriber.NanoMsgSubscriber{NanoMsgUrlLookupdURl}
-	return w.subscribe(sub)
+	return w.NanoMsgSubscriberWithResync(NanoMsgUrlLookupdURl, "")
+}
+
+// NanoMsgSubscriberWithResync subscribes the doorman to nanomsg.  On a gap in
+// the updates, the doorman is resynchronized from the status endpoint of the
+// server serverUrl.
+func (w *Doorman) NanoMsgSubscriberWithResync(NanoMsgUrlLookupdURl, serverUrl string) error {
+	sub := &nanomsgsubscriber.NanoMsgSubscriber{Url: NanoMsgUrlLookupdURl, Instrumentation: w.subscriberInstrumentation(), Logger: w.Logger, Resync: resync(serverUrl)}
+	return w.Subscribe(sub)
+}
+
+func (w *Doorman) NATSSubscriber(NATSUrl string) error {
+	sub := &natssubscriber.NATSSubscriber{Url: NATSUrl, Instrumentation: w.subscriberInstrumentation(), Logger: w.Logger}
+	return w.Subscribe(sub)
+}
+
+func (w *Doorman) KafkaSubscriber(brokers []string, topic string) error {
+	sub := &kafkasubscriber.KafkaSubscriber{Brokers: brokers, Topic: topic, Instrumentation: w.subscriberInstrumentation(), Logger: w.Logger}
+	return w.Subscribe(sub)
 }
 
 func (w *Doorman) Subscriber(serverUrl string) error {
-	sub := &subscriber.Subscriber{serverUrl}
-	return w.subscribe(sub)
+	sub := &subscriber.Subscriber{URL: serverUrl, Instrumentation: w.subscriberInstrumentation(), Logger: w.Logger}
+	return w.Subscribe(sub)
 }
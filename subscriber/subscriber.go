@@ -3,19 +3,25 @@ package subscriber
 import (
 	"encoding/json"
 	"errors"
+	"github.com/didiercrunch/doorman/codec"
 	"github.com/didiercrunch/doorman/httpsubscriber"
 	"github.com/didiercrunch/doorman/nanomsgsubscriber"
+	"github.com/didiercrunch/doorman/natssubscriber"
 	"github.com/didiercrunch/doorman/shared"
 	"net/http"
 	"time"
 )
 
 type Subscriber struct {
-	URL string
+	URL             string
+	Instrumentation shared.Instrumentation // optional, handed to the chosen subscriber
+	Logger          shared.Logger          // optional, handed to the chosen subscriber
+	transport       string                 // the transport of the chosen subscriber
 }
 
 type subscriber interface {
 	Subscribe(abtestId string, update shared.UpdateHandlerFunc) error
+	Transport() string
 }
 
 type ServerSpecification struct {
@@ -23,6 +29,8 @@ type ServerSpecification struct {
 	Port         int               `json:"port"`
 	MessageQueue string            `json:"message_queue"`
 	NanoMsg      map[string]string `json:"nano_msg"`
+	NATS         map[string]string `json:"nats"`            // the url and the optional stream of the last updates
+	Codec        string            `json:"codec,omitempty"` // the codec of the push transports, json by default
 }
 
 func (s *Subscriber) getServerSpecification() (*ServerSpecification, error) {
@@ -42,15 +50,18 @@ func (s *Subscriber) getServerSpecification() (*ServerSpecification, error) {
 }
 
 func (sub *Subscriber) GetSubsciber(serverSpec *ServerSpecification, doormanId string) subscriber {
+	c, _ := codec.ByName(serverSpec.Codec) // checked by Subscribe
 	switch serverSpec.MessageQueue {
 	case "nanomsg":
-		return &nanomsgsubscriber.NanoMsgSubscriber{serverSpec.NanoMsg["url"]}
+		return &nanomsgsubscriber.NanoMsgSubscriber{Url: serverSpec.NanoMsg["url"], Instrumentation: sub.Instrumentation, Logger: sub.Logger, Resync: httpsubscriber.Resync(sub.URL), Codec: c}
+	case "nats":
+		return &natssubscriber.NATSSubscriber{Url: serverSpec.NATS["url"], Stream: serverSpec.NATS["stream"], Instrumentation: sub.Instrumentation, Logger: sub.Logger, Resync: httpsubscriber.Resync(sub.URL), Codec: c}
 	}
-	return &httpsubscriber.HttpSubscriber{sub.getDoormanStatusUrl(doormanId), time.Second * 5}
+	return &httpsubscriber.HttpSubscriber{Url: sub.getDoormanStatusUrl(doormanId), HartBeat: time.Second * 5, Instrumentation: sub.Instrumentation, Logger: sub.Logger}
 }
 
 func (sub *Subscriber) getDoormanStatusUrl(doormanId string) string {
-	return sub.URL + "/api/doormen/" + doormanId + "/status"
+	return httpsubscriber.StatusUrl(sub.URL, doormanId)
 }
 
 func (sub *Subscriber) SetInitialState(doormanId string, update shared.UpdateHandlerFunc) error {
@@ -72,9 +83,18 @@ func (sub *Subscriber) Subscribe(doormanId string, update shared.UpdateHandlerFu
 	if err != nil {
 		return err
 	}
+	if _, err := codec.ByName(spec.Codec); err != nil {
+		return errors.New("cannot decode updates in " + spec.Codec + ": " + err.Error())
+	}
 	if err = sub.SetInitialState(doormanId, update); err != nil {
 		return err
 	}
 	subscriber := sub.GetSubsciber(spec, doormanId)
+	sub.transport = subscriber.Transport()
 	return subscriber.Subscribe(doormanId, update)
 }
+
+// Transport returns the transport chosen by the server, once subscribed.
+func (sub *Subscriber) Transport() string {
+	return sub.transport
+}